@@ -2,6 +2,7 @@ package jinja2
 
 import (
   "errors"
+  "strconv"
   "strings"
 )
 
@@ -11,6 +12,14 @@ import (
 // 2) A mapping of python variable types. If set to a
 //    value other than PY_TYPE_UNDEFINED, this will
 //    become the default value when the call is made.
+//    An argument named with a leading "*" collects any
+//    extra positional args into a list, and one with a
+//    leading "**" collects any extra named args into a
+//    dict, as with python's *args and **kwargs.
+//    An argument whose value has the PY_TYPE_CONTEXT type
+//    is given the *Context the call is made from, in the
+//    same way as jinja2's pass_context, so that the call
+//    can use the filters and tests of the environment.
 type CallableArg struct {
   Name string
   Value VariableType
@@ -36,12 +45,29 @@ func CreateArgumentList(a *ArgList, c *Context) ([]CallableArg, error) {
   return arg_list, nil
 }
 func MakeCall(call PyCallable, incoming_args []CallableArg, c *Context) (VariableType, error) {
-  // FIXME: deal with *args and **kwargs
   args := make([]VariableType, len(call.Args))
   set_list := make([]bool, len(call.Args))
   for idx, _ := range set_list {
     set_list[idx] = false
   }
+  // find the positional slots and the *args/**kwargs catch-alls
+  positional := make([]int, 0)
+  varargs_idx := -1
+  kwargs_idx := -1
+  for idx, call_arg := range call.Args {
+    if call_arg.Value.Type == PY_TYPE_CONTEXT {
+      set_list[idx] = true
+      args[idx] = VariableType{PY_TYPE_CONTEXT, c}
+    } else if strings.HasPrefix(call_arg.Name, "**") {
+      kwargs_idx = idx
+    } else if strings.HasPrefix(call_arg.Name, "*") {
+      varargs_idx = idx
+    } else if varargs_idx == -1 {
+      positional = append(positional, idx)
+    }
+  }
+  varargs := make([]VariableType, 0)
+  kwargs := make(map[VariableType]VariableType)
 
   next_pos := 0
   doing_named_args := false
@@ -51,7 +77,10 @@ func MakeCall(call PyCallable, incoming_args []CallableArg, c *Context) (Variabl
         doing_named_args = true
         found := false
         for idx, call_arg := range call.Args {
-          if arg.Name == call_arg.Name {
+          if arg.Name == call_arg.Name && idx != varargs_idx && idx != kwargs_idx && call_arg.Value.Type != PY_TYPE_CONTEXT {
+            if set_list[idx] {
+              return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("Got multiple values for arg:'" + arg.Name + "'")
+            }
             set_list[idx] = true
             args[idx] = arg.Value
             found = true
@@ -59,18 +88,35 @@ func MakeCall(call PyCallable, incoming_args []CallableArg, c *Context) (Variabl
           }
         }
         if !found {
-          return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("Unknown named arg:'" + arg.Name + "'")
+          if kwargs_idx == -1 {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("Unknown named arg:'" + arg.Name + "'")
+          }
+          kwargs[VariableType{PY_TYPE_STRING, arg.Name}] = arg.Value
         }
       } else {
         if doing_named_args {
           return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("Positional arg found after a named arg.")
         }
-        set_list[next_pos] = true
-        args[next_pos] = arg.Value
-        next_pos += 1
+        if next_pos < len(positional) {
+          set_list[positional[next_pos]] = true
+          args[positional[next_pos]] = arg.Value
+          next_pos += 1
+        } else if varargs_idx != -1 {
+          varargs = append(varargs, arg.Value)
+        } else {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("Too many positional args for call.")
+        }
       }
     }
   }
+  if varargs_idx != -1 {
+    set_list[varargs_idx] = true
+    args[varargs_idx] = VariableType{PY_TYPE_LIST, varargs}
+  }
+  if kwargs_idx != -1 {
+    set_list[kwargs_idx] = true
+    args[kwargs_idx] = VariableType{PY_TYPE_DICT, kwargs}
+  }
   // now we validate all args were set, and if not we use the
   // default value provided in the call args. If there is no
   // default specified, we return an error.
@@ -78,13 +124,16 @@ func MakeCall(call PyCallable, incoming_args []CallableArg, c *Context) (Variabl
     if !set_status {
       call_arg := call.Args[idx]
       if call_arg.Value.Type == PY_TYPE_UNDEFINED {
-        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("Required argument for call was not set.")
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("Required argument '" + call_arg.Name + "' for call was not set.")
       } else {
         args[idx] = call_arg.Value
       }
     }
   }
   // Args are matched and validated, so we make the call
+  if err := c.budget.call(); err != nil {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, err
+  }
   return call.Method(args)
 }
 //-------------------------------------------------------------------------------------------------
@@ -92,53 +141,68 @@ func MakeCall(call PyCallable, incoming_args []CallableArg, c *Context) (Variabl
 func ProcessJ2Filters(val VariableType, filters []*J2Filter, c *Context) (VariableType, error) {
   running_res := val
   for _, filter := range filters {
-    filter_name := *filter.Name
-    if filter_func, ok := c.Filters[filter_name]; !ok {
-      return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("the filter '" + filter_name + "' was not found.")
-    } else {
-      arg_list, arg_err := CreateArgumentList(filter.Args, c)
-      if arg_err != nil {
-        return VariableType{PY_TYPE_UNDEFINED, nil}, arg_err
-      }
-      // for filters and tests, the first argument to the call is
-      // the current value to the left of the filter chain
-      arg_list = append([]CallableArg{CallableArg{"val", val}}, arg_list...)
-      new_res, err := MakeCall(filter_func, arg_list, c)
-      if err != nil {
-        return VariableType{PY_TYPE_UNDEFINED, nil}, err
-      }
-      running_res = new_res
+    arg_list, arg_err := CreateArgumentList(filter.Args, c)
+    if arg_err != nil {
+      return VariableType{PY_TYPE_UNDEFINED, nil}, arg_err
     }
+    new_res, err := c.callFilter(*filter.Name, running_res, arg_list)
+    if err != nil {
+      return VariableType{PY_TYPE_UNDEFINED, nil}, err
+    }
+    running_res = new_res
   }
   return running_res, nil
 }
+// callFilter calls the named filter on the value with the args, as if
+// it had been used in the template.
+func (self *Context) callFilter(filter_name string, val VariableType, arg_list []CallableArg) (VariableType, error) {
+  filter_func, ok := self.LookupFilter(filter_name)
+  if !ok {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("the filter '" + filter_name + "' was not found.")
+  }
+  if err := self.checkCallable(CALLABLE_FILTER, filter_name); err != nil {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, err
+  }
+  // for filters and tests, the first argument to the call is
+  // the current value to the left of the filter chain. It is
+  // passed positionally so the filter args can follow it.
+  arg_list = append([]CallableArg{CallableArg{"", val}}, arg_list...)
+  return MakeCall(filter_func, arg_list, self)
+}
 //-------------------------------------------------------------------------------------------------
 func ProcessJ2Test(val VariableType, test *J2Test, c *Context) (VariableType, error) {
-  test_name := *test.Name
-  if test_func, ok := c.Tests[test_name]; !ok {
-    return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("the test '" + test_name + "' was not found.")
-  } else {
-    arg_list, arg_err := CreateArgumentList(test.Args, c)
-    if arg_err != nil {
-      return VariableType{PY_TYPE_UNDEFINED, nil}, arg_err
-    }
-    // for filters and tests, the first argument to the call is
-    // the current value to the left of the filter chain
-    arg_list = append([]CallableArg{CallableArg{"val", val}}, arg_list...)
-    new_res, err := MakeCall(test_func, arg_list, c)
+  arg_list, arg_err := CreateArgumentList(test.Args, c)
+  if arg_err != nil {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, arg_err
+  }
+  new_res, err := c.callTest(*test.Name, val, arg_list)
+  if err != nil {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, err
+  }
+  if *test.Negated == "not" {
+    b_val, err := new_res.AsBool()
     if err != nil {
       return VariableType{PY_TYPE_UNDEFINED, nil}, err
     }
-    if *test.Negated == "not" {
-      b_val, err := new_res.AsBool()
-      if err != nil {
-        return VariableType{PY_TYPE_UNDEFINED, nil}, err
-      }
-      return VariableType{PY_TYPE_BOOL, !b_val}, nil
-    }
-    // FIXME: should all tests be bools?
-    return new_res, nil
+    return VariableType{PY_TYPE_BOOL, !b_val}, nil
   }
+  // FIXME: should all tests be bools?
+  return new_res, nil
+}
+// callTest calls the named test on the value with the args, as if it
+// had been used in the template.
+func (self *Context) callTest(test_name string, val VariableType, arg_list []CallableArg) (VariableType, error) {
+  test_func, ok := self.LookupTest(test_name)
+  if !ok {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("the test '" + test_name + "' was not found.")
+  }
+  if err := self.checkCallable(CALLABLE_TEST, test_name); err != nil {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, err
+  }
+  // for filters and tests, the first argument to the call is
+  // the current value to the left of the filter chain
+  arg_list = append([]CallableArg{CallableArg{"", val}}, arg_list...)
+  return MakeCall(test_func, arg_list, self)
 }
 //-------------------------------------------------------------------------------------------------
 type VariableStatement struct {
@@ -155,6 +219,142 @@ type ForStatement struct {
   Recursive bool `[@"recursive"]`
 }
 //-------------------------------------------------------------------------------------------------
+type ExtendsStatement struct {
+  Template *Test `"extends" @@`
+}
+func (self *ExtendsStatement) Eval(c *Context) (VariableType, error) {
+  return self.Template.Eval(c)
+}
+//-------------------------------------------------------------------------------------------------
+type BlockStatement struct {
+  Name      *string  `"block" @Ident`
+  Modifiers []string `{ @("scoped"|"required") }`
+}
+func (self *BlockStatement) HasModifier(modifier string) bool {
+  for _, m := range self.Modifiers {
+    if m == modifier {
+      return true
+    }
+  }
+  return false
+}
+//-------------------------------------------------------------------------------------------------
+type IncludeStatement struct {
+  Template      *Test   `"include" @@`
+  IgnoreMissing bool    `[ @"ignore" "missing" ]`
+  Context       *string `[ @("with"|"without") "context" ]`
+}
+func (self *IncludeStatement) Eval(c *Context) (VariableType, error) {
+  return self.Template.Eval(c)
+}
+func (self *IncludeStatement) WithContext() bool {
+  return self.Context == nil || *self.Context == "with"
+}
+//-------------------------------------------------------------------------------------------------
+type MacroStatement struct {
+  Name   *string       `"macro" @Ident`
+  Params []*MacroParam `"(" [ @@ { "," @@ }[","] ] ")"`
+}
+//-------------------------------------------------------------------------------------------------
+type MacroParam struct {
+  Name    *string `@Ident`
+  Default *Test   `[ "=" @@ ]`
+}
+// BindMacroArgs sets the macro parameters in the context from the
+// positional and named args of the call. Extra positional args are
+// available as `varargs` and extra named args as `kwargs`. Parameter
+// defaults are evaluated at call time, so they can refer to the
+// parameters before them.
+func BindMacroArgs(params []*MacroParam, pos_args []VariableType, named_args map[VariableType]VariableType, c *Context) error {
+  kwargs := make(map[VariableType]VariableType)
+  for k, v := range named_args {
+    kwargs[k] = v
+  }
+  for idx, param := range params {
+    key := VariableType{PY_TYPE_STRING, *param.Name}
+    if idx < len(pos_args) {
+      if _, ok := kwargs[key]; ok {
+        return errors.New("macro got multiple values for argument '" + *param.Name + "'")
+      }
+      c.Variables[*param.Name] = pos_args[idx]
+    } else if v, ok := kwargs[key]; ok {
+      c.Variables[*param.Name] = v
+      delete(kwargs, key)
+    } else if param.Default != nil {
+      v, err := param.Default.Eval(c)
+      if err != nil {
+        return err
+      }
+      c.Variables[*param.Name] = v
+    }
+  }
+  varargs := make([]VariableType, 0)
+  if len(pos_args) > len(params) {
+    varargs = append(varargs, pos_args[len(params):]...)
+  }
+  c.Variables["varargs"] = VariableType{PY_TYPE_LIST, varargs}
+  c.Variables["kwargs"] = VariableType{PY_TYPE_DICT, kwargs}
+  return nil
+}
+//-------------------------------------------------------------------------------------------------
+type CallStatement struct {
+  Params []*MacroParam `"call" [ "(" [ @@ { "," @@ }[","] ] ")" ]`
+  Call   *AtomExpr     `@@`
+}
+//-------------------------------------------------------------------------------------------------
+type ImportStatement struct {
+  Template *Test   `"import" @@`
+  Alias    *string `"as" @Ident`
+  Context  *string `[ @("with"|"without") "context" ]`
+}
+func (self *ImportStatement) Eval(c *Context) (VariableType, error) {
+  return self.Template.Eval(c)
+}
+func (self *ImportStatement) WithContext() bool {
+  // unlike includes, imports don't get the context by default
+  return self.Context != nil && *self.Context == "with"
+}
+//-------------------------------------------------------------------------------------------------
+type FromImportStatement struct {
+  Template *Test         `"from" @@`
+  Names    []*ImportName `"import" @@ { "," @@ }[","]`
+  Context  *string       `[ @("with"|"without") "context" ]`
+}
+func (self *FromImportStatement) Eval(c *Context) (VariableType, error) {
+  return self.Template.Eval(c)
+}
+func (self *FromImportStatement) WithContext() bool {
+  return self.Context != nil && *self.Context == "with"
+}
+//-------------------------------------------------------------------------------------------------
+type ImportName struct {
+  Name  *string `@Ident`
+  Alias *string `[ "as" @Ident ]`
+}
+//-------------------------------------------------------------------------------------------------
+type SetStatement struct {
+  TargetList *TargetList `"set" @@`
+  Value      *TestList   `[ "=" @@ ]`
+  Filters    []*J2Filter `{ "|" @@ }`
+}
+//-------------------------------------------------------------------------------------------------
+type FilterStatement struct {
+  Filters []*J2Filter `"filter" @@ { "|" @@ }`
+}
+//-------------------------------------------------------------------------------------------------
+type AutoescapeStatement struct {
+  Value *Test `"autoescape" @@`
+}
+//-------------------------------------------------------------------------------------------------
+type WithStatement struct {
+  Assignments []*WithAssignment `"with" [ @@ { "," @@ }[","] ]`
+}
+//-------------------------------------------------------------------------------------------------
+type WithAssignment struct {
+  Name  *string `@Ident "="`
+  Value *Test   `@@`
+}
+//-------------------------------------------------------------------------------------------------
 type IfStatement struct {
   Test *Test `"if" @@`
 }
@@ -188,6 +388,23 @@ func (self *ElifStatement) Eval(c *Context) (VariableType, error) {
 type TestList struct {
   Tests []*Test `@@ { "," @@ }[","]`
 }
+// Eval returns the value of the test if there is only one, otherwise
+// the values are returned together as a list (python would make this
+// a tuple).
+func (self *TestList) Eval(c *Context) (VariableType, error) {
+  if len(self.Tests) == 1 {
+    return self.Tests[0].Eval(c)
+  }
+  res := make([]VariableType, len(self.Tests))
+  for idx, test := range self.Tests {
+    test_res, err := test.Eval(c)
+    if err != nil {
+      return VariableType{PY_TYPE_UNDEFINED, nil}, err
+    }
+    res[idx] = test_res
+  }
+  return VariableType{PY_TYPE_LIST, res}, nil
+}
 //-------------------------------------------------------------------------------------------------
 type Test struct {
   Or *OrTest ` @@ `
@@ -280,8 +497,8 @@ func (self *NotTest) Eval(c *Context) (VariableType, error) {
     if err != nil {
       return VariableType{PY_TYPE_UNDEFINED, nil}, err
     } else {
-      if v, ok := res.Data.(bool); !ok {
-        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("error converting 'not' result to a boolean value for negation")
+      if v, err := res.AsBool(); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
       } else {
         return VariableType{PY_TYPE_BOOL, !v}, nil
       }
@@ -315,6 +532,21 @@ func (self *Comparison) Eval(c *Context) (VariableType, error) {
       if r_err != nil {
         return VariableType{PY_TYPE_UNDEFINED, nil}, r_err
       }
+      if l_res.Type == PY_TYPE_UNDEFINED || r_res.Type == PY_TYPE_UNDEFINED {
+        // an undefined value is only equal to another undefined value
+        if *opexpr.Op != "==" && *opexpr.Op != "!=" {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, undefinedOperand(l_res, r_res)
+        }
+        for _, v := range []VariableType{l_res, r_res} {
+          if v.Type == PY_TYPE_UNDEFINED && AsUndefined(v).Policy == UNDEFINED_STRICT {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, AsUndefined(v).Error()
+          }
+        }
+        equal := l_res.Type == r_res.Type
+        final_result = final_result && (equal == (*opexpr.Op == "=="))
+        l_res = r_res
+        continue
+      }
       if l_res.Type != r_res.Type {
         return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("mismatched types for comparison")
       }
@@ -387,6 +619,9 @@ func (self *Expr) Eval(c *Context) (VariableType, error) {
       if rhs_res, err := rhs.Eval(c); err != nil {
         return VariableType{PY_TYPE_UNDEFINED, nil}, err
       } else {
+        if err := undefinedOperand(cur_res, rhs_res); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
         if cur_res.Type == PY_TYPE_FLOAT || rhs_res.Type == PY_TYPE_FLOAT {
           // need to convert to floats
           l_val, l_err := cur_res.AsFloat()
@@ -413,7 +648,7 @@ func (self *Expr) Eval(c *Context) (VariableType, error) {
           case "-":
             cur_res = VariableType{PY_TYPE_INT, l_val - r_val}
           }
-        } else if cur_res.Type == PY_TYPE_STRING && rhs_res.Type == PY_TYPE_STRING {
+        } else if (cur_res.Type == PY_TYPE_STRING || cur_res.Type == PY_TYPE_MARKUP) && (rhs_res.Type == PY_TYPE_STRING || rhs_res.Type == PY_TYPE_MARKUP) {
           // string join
           if *rhs.Op == "-" {
             return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("unsupported op '"+ (*rhs.Op) +"' for a (string) and (string))")
@@ -423,7 +658,18 @@ func (self *Expr) Eval(c *Context) (VariableType, error) {
           if l_err != nil || r_err != nil {
             return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("unsupported op '"+ (*rhs.Op) +"' for a (fixme) and (fixme))")
           }
-          cur_res = VariableType{PY_TYPE_STRING, l_val + r_val}
+          if cur_res.Type == PY_TYPE_MARKUP || rhs_res.Type == PY_TYPE_MARKUP {
+            // joining markup with a plain string escapes the plain side
+            if cur_res.Type != PY_TYPE_MARKUP {
+              l_val = EscapeString(l_val)
+            }
+            if rhs_res.Type != PY_TYPE_MARKUP {
+              r_val = EscapeString(r_val)
+            }
+            cur_res = VariableType{PY_TYPE_MARKUP, l_val + r_val}
+          } else {
+            cur_res = VariableType{PY_TYPE_STRING, l_val + r_val}
+          }
         } else {
           // error, can't do math between disparate types
           return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("unsupported op '"+ (*rhs.Op) +"' for a (fixme) and (fixme))")
@@ -459,6 +705,9 @@ func (self *ArithExpr) Eval(c *Context) (VariableType, error) {
       if rhs_res, err := rhs.Eval(c); err != nil {
         return VariableType{PY_TYPE_UNDEFINED, nil}, err
       } else {
+        if err := undefinedOperand(cur_res, rhs_res); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
         if cur_res.Type == PY_TYPE_FLOAT || rhs_res.Type == PY_TYPE_FLOAT {
           // need to convert to floats
           l_val, l_err := cur_res.AsFloat()
@@ -588,16 +837,8 @@ type Power struct {
 }
 func (self *Power) Eval(c *Context) (VariableType, error) {
   atom_res, err := self.AtomExpr.Eval(c)
-  var deferred_err error = nil
   if err != nil {
-    // FIXME: not sure if this is the best way to handle this, as
-    //        the deferred error will most likely be ignored if any
-    //        test is successfully run, masking the error
-    if self.Test == nil {
-      return VariableType{PY_TYPE_UNDEFINED, nil}, err
-    } else {
-      deferred_err = err
-    }
+    return VariableType{PY_TYPE_UNDEFINED, nil}, err
   }
   /*
   // FIXME: implement powers
@@ -618,10 +859,9 @@ func (self *Power) Eval(c *Context) (VariableType, error) {
     if err != nil {
       return VariableType{PY_TYPE_UNDEFINED, nil}, err
     }
-    deferred_err = nil
     return_res = res
   }
-  return return_res, deferred_err
+  return return_res, nil
 }
 //-------------------------------------------------------------------------------------------------
 type AtomExpr struct {
@@ -629,61 +869,140 @@ type AtomExpr struct {
   Trailers []*Trailer `{ @@ }`
 }
 func (self *AtomExpr) Eval(c *Context) (VariableType, error) {
+  return self.EvalWithArgs(c, nil)
+}
+// EvalWithArgs evaluates the expression, adding the extra args to the
+// call made by the final trailer. This is how the `call` tag passes the
+// caller to a macro.
+func (self *AtomExpr) EvalWithArgs(c *Context, extra_args []CallableArg) (VariableType, error) {
   atom_res, err := self.Atom.Eval(c)
+  if err != nil {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, err
+  }
+  // the name the value was found by, which is what a call on it is
+  // checked against when sandboxed
+  name := ""
+  if atom_res.Type == PY_TYPE_IDENT {
+    name = atom_res.Data.(string)
+  }
   if self.Trailers != nil {
-    for _, t := range self.Trailers {
+    for idx, t := range self.Trailers {
       if t.Name != nil {
         // this is a sub-key in a dictionary or an attribute on the
         // class, so we set the running value to whichever it is.
-        if atom_res.Type == PY_TYPE_DICT {
+        atom_res, err = ResolveIdentifier(atom_res, c)
+        if err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        owner := atom_res
+        if atom_res.Type == PY_TYPE_UNDEFINED {
+          // only a chainable undefined allows looking up attributes
+          // on it, which just gives back the same undefined value
+          if u := AsUndefined(atom_res); u.Policy != UNDEFINED_CHAINABLE {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, u.Error()
+          }
+        } else if atom_res.Type == PY_TYPE_DICT {
           if sub_dict, ok := atom_res.Data.(map[VariableType]VariableType); !ok {
-            // FIXME: error
+            return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("error converting dict variable for attribute lookup")
           } else {
             if v, ok := sub_dict[VariableType{PY_TYPE_STRING, *t.Name}]; !ok {
-              // FIXME: error
+              atom_res = c.undefinedAttribute(atom_res, *t.Name)
             } else {
               atom_res = v
             }
           }
+        } else if atom_res.Type == PY_TYPE_MODULE {
+          module := atom_res.Data.(*TemplateModule)
+          if v, ok := module.Exports[*t.Name]; !ok {
+            atom_res = c.undefinedAttribute(atom_res, *t.Name)
+          } else {
+            atom_res = v
+          }
+        } else if named, ok := atom_res.Data.(NamedTuple); ok && atom_res.Type == PY_TYPE_TUPLE {
+          found := false
+          for idx, item_name := range named.Names {
+            if item_name == *t.Name {
+              atom_res, found = named.Items[idx], true
+              break
+            }
+          }
+          if !found {
+            atom_res = c.undefinedAttribute(atom_res, *t.Name)
+          }
         } else {
-          // class/struct?
+          // FIXME: class/struct attributes
+          atom_res = c.undefinedAttribute(atom_res, *t.Name)
         }
+        if owner.Type != PY_TYPE_UNDEFINED {
+          if err := c.checkAttribute(owner, *t.Name, atom_res); err != nil {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, err
+          }
+        }
+        name = *t.Name
       } else if t.ArgList != nil {
         // this is a callable, so we need to lookup which
         // method is being called and pass the args to it, then
         // we assign the result to the running value.
-        if atom_res.Type != PY_TYPE_IDENT {
-          // FIXME: better error here
-          return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("Cannot make a call on a non-identifier.")
+        call_func, err := LookupCallable(atom_res, c)
+        if err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
         }
-        call_name := atom_res.Data.(string)
-        if call_func, ok := c.PyCalls[call_name]; !ok {
-          return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("the method '" + call_name + "' was not found.")
-        } else {
-          arg_list, arg_err := CreateArgumentList(t.ArgList, c)
-          if arg_err != nil {
-            return VariableType{PY_TYPE_UNDEFINED, nil}, arg_err
-          }
-          new_res, err := MakeCall(call_func, arg_list, c)
-          if err != nil {
-            return VariableType{PY_TYPE_UNDEFINED, nil}, err
-          }
-          atom_res = new_res
+        if err := c.checkCallable(CALLABLE_CALL, name); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        arg_list, arg_err := CreateArgumentList(t.ArgList, c)
+        if arg_err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, arg_err
+        }
+        if idx == len(self.Trailers) - 1 {
+          arg_list = append(arg_list, extra_args...)
         }
+        new_res, err := MakeCall(call_func, arg_list, c)
+        if err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        atom_res = new_res
+        name = ""
       }
     }
   }
   // if we have an identifier, we do the variable lookup here
-  if atom_res.Type == PY_TYPE_IDENT {
-    var_name := atom_res.Data.(string)
-    if v, ok := c.Variables[var_name]; ok {
-      return v, nil
+  return ResolveIdentifier(atom_res, c)
+}
+// ResolveIdentifier looks up the value of an identifier in the
+// context. Any other value is returned unchanged.
+func ResolveIdentifier(val VariableType, c *Context) (VariableType, error) {
+  if val.Type != PY_TYPE_IDENT {
+    return val, nil
+  }
+  var_name := val.Data.(string)
+  if v, ok := c.LookupVariable(var_name); ok {
+    return v, nil
+  } else {
+    return c.undefinedVariable(var_name), nil
+  }
+}
+// LookupCallable finds the callable for the value to the left of a
+// call trailer. Identifiers are looked up in the registered PyCalls
+// first, then in the variables for callable values.
+func LookupCallable(val VariableType, c *Context) (PyCallable, error) {
+  if val.Type == PY_TYPE_IDENT {
+    call_name := val.Data.(string)
+    if call_func, ok := c.LookupPyCall(call_name); ok {
+      return call_func, nil
+    }
+    if v, ok := c.LookupVariable(call_name); !ok {
+      return PyCallable{}, AsUndefined(c.undefinedVariable(call_name)).Error()
     } else {
-      return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("variable name '"+var_name+"' was not found in the current context.")
+      val = v
     }
-  } else {
-    return atom_res, err
   }
+  if val.Type == PY_TYPE_UNDEFINED {
+    return PyCallable{}, AsUndefined(val).Error()
+  } else if val.Type != PY_TYPE_CALLABLE {
+    return PyCallable{}, errors.New("Cannot make a call on a non-callable '" + PyTypeToString(val.Type) + "' object.")
+  }
+  return val.Data.(PyCallable), nil
 }
 //-------------------------------------------------------------------------------------------------
 type Atom struct {
@@ -744,9 +1063,11 @@ func (self *ArgList) Eval(c *Context) (VariableType, error) {
 type Argument struct {
   //NamedArg  *NamedArgument  `  @@`
   //AnonArg   *AnonArgument   `| @@`
-  AnonValue *Test `@@ |`
+  // named arguments are tried first, otherwise the name would be
+  // consumed as a positional argument and the "=" would fail to parse
   Name *string `( @Ident`
   Value *Test  `"=" @@ )`
+  AnonValue *Test `| @@`
 }
 func (self *Argument) Eval(c *Context) (VariableType, error) {
   /*
@@ -756,7 +1077,10 @@ func (self *Argument) Eval(c *Context) (VariableType, error) {
     return self.AnonArg.Eval(c)
   }
   */
-  return self.Value.Eval(c)
+  if self.Name != nil {
+    return self.Value.Eval(c)
+  }
+  return self.AnonValue.Eval(c)
 }
 //-------------------------------------------------------------------------------------------------
 type NamedArgument struct {
@@ -825,6 +1149,27 @@ type KeyDatum struct {
 type TargetList struct {
   Targets []*Target ` @@ { "," @@ }[","] `
 }
+// Assign sets the targets in the context to the value, unpacking the
+// value when there is more than one target.
+func (self *TargetList) Assign(value VariableType, c *Context) error {
+  target_len := len(self.Targets)
+  if target_len != 1 {
+    v_list, err := value.AsList()
+    if err != nil {
+      return errors.New("Cannot assign a single value to multiple targets.")
+    }
+    item_len := len(v_list)
+    if item_len != target_len {
+      return errors.New("Cannot assign "+strconv.Itoa(item_len)+" values to "+strconv.Itoa(target_len)+" targets.")
+    }
+    for idx, target := range self.Targets {
+      c.Variables[*target.Name] = v_list[idx]
+    }
+  } else {
+    c.Variables[*self.Targets[0].Name] = value
+  }
+  return nil
+}
 //-------------------------------------------------------------------------------------------------
 type Target struct {
   Name *string `@Ident`
@@ -0,0 +1,59 @@
+package jinja2
+
+import (
+  "testing"
+)
+
+func TestAutoescapeSelection(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "page.html": "<p>{{ text }}</p>",
+    "page.txt": "<p>{{ text }}</p>",
+  }))
+  env.Autoescape = SelectAutoescape([]string{"html", "xml"}, nil, false, false)
+  vars := map[string]interface{}{"text": `<b>Tom & "Jerry"</b>`}
+  expected := "<p>&lt;b&gt;Tom &amp; &#34;Jerry&#34;&lt;/b&gt;</p>"
+  if res := renderFromEnv(t, env, "page.html", vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  expected = `<p><b>Tom & "Jerry"</b></p>`
+  if res := renderFromEnv(t, env, "page.txt", vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestAutoescapeMarkup(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "page.html": "{{ html }} {{ html|e }} {{ html|forceescape }} {{ text|safe }} {{ text|e|e }}",
+  }))
+  env.Autoescape = func(string) bool { return true }
+  vars := map[string]interface{}{"html": Markup("<br>"), "text": "a<b"}
+  expected := "<br> <br> &lt;br&gt; a<b a&lt;b"
+  if res := renderFromEnv(t, env, "page.html", vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestMarkupConcatenation(t *testing.T) {
+  source := "{% set close = '</i>'|safe %}{{ open + text + close }}|{{ text + text }}"
+  expected := "<i>&lt;x&gt;</i>|<x><x>"
+  if res := renderString(t, source, map[string]interface{}{"open": Markup("<i>"), "text": "<x>"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestAutoescapeTag(t *testing.T) {
+  source := "{{ v }}{% autoescape true %}{{ v }}{% autoescape false %}{{ v }}{% endautoescape %}{{ v }}{% endautoescape %}{{ v }}"
+  expected := "<&lt;<&lt;<"
+  if res := renderString(t, source, map[string]interface{}{"v": "<"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestAutoescapeMacrosAndBlocks(t *testing.T) {
+  // rendered sections are already escaped, so they are not escaped twice
+  source := "{% autoescape true %}{% macro b(t) %}<b>{{ t }}</b>{% endmacro %}{{ b(v) }}{% set s %}<i>{{ v }}</i>{% endset %}{{ s }}{% filter upper %}<u>{{ v }}</u>{% endfilter %}{% endautoescape %}"
+  expected := "<b>&lt;</b><i>&lt;</i><U>&LT;</U>"
+  if res := renderString(t, source, map[string]interface{}{"v": "<"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
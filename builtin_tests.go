@@ -0,0 +1,195 @@
+package jinja2
+
+import (
+  "errors"
+  "strings"
+)
+
+//-------------------------------------------------------------------------------------------------
+// The builtin tests take the same arguments as the Jinja2 tests of the
+// same name. The comparison tests are also registered under the names
+// of their operators, for use with filters like select and selectattr.
+
+// equalValues compares two values for equality the way python's ==
+// does, where values of types which can't be compared are not equal.
+func equalValues(l VariableType, r VariableType) bool {
+  if l.Type == PY_TYPE_DICT && r.Type == PY_TYPE_DICT {
+    l_dict, _ := l.Data.(map[VariableType]VariableType)
+    r_dict, _ := r.Data.(map[VariableType]VariableType)
+    if len(l_dict) != len(r_dict) {
+      return false
+    }
+    for k, l_v := range l_dict {
+      if r_v, ok := r_dict[k]; !ok || !equalValues(l_v, r_v) {
+        return false
+      }
+    }
+    return true
+  }
+  if (l.Type == PY_TYPE_LIST || l.Type == PY_TYPE_TUPLE) && l.Type == r.Type {
+    l_items, _ := l.AsList()
+    r_items, _ := r.AsList()
+    if len(l_items) != len(r_items) {
+      return false
+    }
+    for idx, item := range l_items {
+      if !equalValues(item, r_items[idx]) {
+        return false
+      }
+    }
+    return true
+  }
+  res, err := compareValues(l, r, true)
+  return err == nil && res == 0
+}
+
+// boolTest makes a test from a function of the value.
+func boolTest(f func(VariableType) bool) PyCallable {
+  return PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return VariableType{PY_TYPE_BOOL, f(args[0])}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+}
+
+// compareTest makes a test comparing the value with another, where
+// want says which results of compareValues pass.
+func compareTest(want func(int) bool) PyCallable {
+  return PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      res, err := compareValues(args[0], args[1], true)
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      return VariableType{PY_TYPE_BOOL, want(res)}, nil
+    }, []CallableArg {
+      {"a", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"b", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+}
+
+// intTest makes a test from a function of the value as an integer.
+func intTest(f func(int64) bool) PyCallable {
+  return PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      if args[0].Type != PY_TYPE_INT {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("expected an int, got '" + PyTypeToString(args[0].Type) + "'")
+      }
+      return VariableType{PY_TYPE_BOOL, f(args[0].Data.(int64))}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+}
+
+func addBuiltinTests(tests map[string]PyCallable) {
+  tests["odd"] = intTest(func(n int64) bool { return n % 2 != 0 })
+  tests["even"] = intTest(func(n int64) bool { return n % 2 == 0 })
+  tests["divisibleby"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      value, err := args[0].AsInt()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      num, err := args[1].AsInt()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      if num == 0 {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("integer division or modulo by zero")
+      }
+      return VariableType{PY_TYPE_BOOL, value % num == 0}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"num", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  tests["none"] = boolTest(func(v VariableType) bool { return v.Type == PY_TYPE_NONE })
+  tests["boolean"] = boolTest(func(v VariableType) bool { return v.Type == PY_TYPE_BOOL })
+  tests["true"] = boolTest(func(v VariableType) bool { return v.Type == PY_TYPE_BOOL && v.Data.(bool) })
+  tests["false"] = boolTest(func(v VariableType) bool { return v.Type == PY_TYPE_BOOL && !v.Data.(bool) })
+  tests["integer"] = boolTest(func(v VariableType) bool { return v.Type == PY_TYPE_INT })
+  tests["float"] = boolTest(func(v VariableType) bool { return v.Type == PY_TYPE_FLOAT })
+  tests["number"] = boolTest(func(v VariableType) bool {
+    return v.Type == PY_TYPE_INT || v.Type == PY_TYPE_FLOAT || v.Type == PY_TYPE_BOOL
+  })
+  tests["string"] = boolTest(func(v VariableType) bool {
+    return v.Type == PY_TYPE_STRING || v.Type == PY_TYPE_MARKUP
+  })
+  tests["mapping"] = boolTest(func(v VariableType) bool { return v.Type == PY_TYPE_DICT })
+  tests["sequence"] = boolTest(func(v VariableType) bool {
+    switch v.Type {
+    case PY_TYPE_LIST, PY_TYPE_TUPLE, PY_TYPE_DICT, PY_TYPE_STRING, PY_TYPE_MARKUP:
+      return true
+    }
+    return false
+  })
+  tests["iterable"] = tests["sequence"]
+  tests["callable"] = boolTest(func(v VariableType) bool { return v.Type == PY_TYPE_CALLABLE })
+  tests["escaped"] = boolTest(func(v VariableType) bool { return v.Type == PY_TYPE_MARKUP })
+  tests["lower"] = boolTest(func(v VariableType) bool {
+    s, err := VariableResToString(v)
+    return err == nil && s == strings.ToLower(s)
+  })
+  tests["upper"] = boolTest(func(v VariableType) bool {
+    s, err := VariableResToString(v)
+    return err == nil && s == strings.ToUpper(s)
+  })
+  tests["eq"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return VariableType{PY_TYPE_BOOL, equalValues(args[0], args[1])}, nil
+    }, []CallableArg {
+      {"a", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"b", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  tests["ne"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return VariableType{PY_TYPE_BOOL, !equalValues(args[0], args[1])}, nil
+    }, []CallableArg {
+      {"a", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"b", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  tests["lt"] = compareTest(func(res int) bool { return res < 0 })
+  tests["le"] = compareTest(func(res int) bool { return res <= 0 })
+  tests["gt"] = compareTest(func(res int) bool { return res > 0 })
+  tests["ge"] = compareTest(func(res int) bool { return res >= 0 })
+  tests["in"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      switch args[1].Type {
+      case PY_TYPE_STRING, PY_TYPE_MARKUP:
+        s, err := VariableResToString(args[0])
+        if err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        seq, _ := args[1].AsString()
+        return VariableType{PY_TYPE_BOOL, strings.Contains(seq, s)}, nil
+      }
+      items, err := iterItems(args[1])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      for _, item := range items {
+        if equalValues(args[0], item) {
+          return VariableType{PY_TYPE_BOOL, true}, nil
+        }
+      }
+      return VariableType{PY_TYPE_BOOL, false}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"seq", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  // the aliases, which include the operators
+  for alias, name := range map[string]string{
+    "equalto": "eq", "==": "eq", "!=": "ne",
+    "lessthan": "lt", "<": "lt", "<=": "le",
+    "greaterthan": "gt", ">": "gt", ">=": "ge",
+  } {
+    tests[alias] = tests[name]
+  }
+}
@@ -0,0 +1,206 @@
+package jinja2
+
+import (
+  "crypto/sha1"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+)
+
+//-------------------------------------------------------------------------------------------------
+// A BytecodeCache stores parsed templates between runs, so that
+// templates whose source hasn't changed don't have to be parsed again.
+// The environment serializes the parsed template, and the cache only
+// has to store the bytes under the key it is given. Load returns nil
+// data without an error when there is nothing stored for the key.
+//
+// The cache is only an optimization, so the environment falls back to
+// parsing the source whenever loading from it or storing to it fails.
+type BytecodeCache interface {
+  Load(key string) ([]byte, error)
+  Dump(key string, data []byte) error
+}
+
+// Bumped whenever the parsed form of templates changes, so that
+// anything cached by an older version is parsed again.
+const bytecode_version = 2
+
+// compiledTemplate is what gets stored in the cache. The checksum is
+// of the source and the lexer settings used to parse it.
+type compiledTemplate struct {
+  Version int
+  Checksum string
+  Chunks RenderableList
+}
+
+// bytecodeCacheKey is the key a template is stored under, which is the
+// same for every version of the template's source.
+func bytecodeCacheKey(name string, filename string) string {
+  sum := sha1.Sum([]byte(name + "|" + filename))
+  return hex.EncodeToString(sum[:])
+}
+
+// sourceChecksum identifies the source of a template along with the
+// settings which change how it is parsed.
+func sourceChecksum(source string, config LexerConfig) (string, error) {
+  config_data, err := json.Marshal(config)
+  if err != nil {
+    return "", err
+  }
+  sum := sha256.Sum256(append(append(config_data, 0), source...))
+  return hex.EncodeToString(sum[:]), nil
+}
+
+// dumpBytecode serializes the parsed template.
+func (self *Template) dumpBytecode(checksum string) ([]byte, error) {
+  return json.Marshal(compiledTemplate{bytecode_version, checksum, self.template_chunks})
+}
+
+// loadBytecode sets the template up from the serialized form, provided
+// it was made from the same source and settings.
+func (self *Template) loadBytecode(source string, checksum string, data []byte) error {
+  var compiled compiledTemplate
+  if err := json.Unmarshal(data, &compiled); err != nil {
+    return err
+  }
+  if compiled.Version != bytecode_version || compiled.Checksum != checksum {
+    return errors.New("the cached template is out of date")
+  }
+  return self.setChunks(source, compiled.Chunks)
+}
+
+//-------------------------------------------------------------------------------------------------
+// RenderableList is a list of chunks which can be serialized as JSON,
+// which needs the type of each chunk to be recorded along with it.
+type RenderableList []Renderable
+
+type encodedChunk struct {
+  Type string
+  Chunk json.RawMessage
+}
+
+var chunk_types = makeChunkTypes(
+  &DummyChunk{}, &TextChunk{}, &RawChunk{}, &VariableChunk{}, &IfChunk{}, &ForChunk{},
+  &SetChunk{}, &FilterChunk{}, &AutoescapeChunk{}, &WithChunk{}, &ExtendsChunk{},
+  &BlockChunk{}, &IncludeChunk{}, &MacroChunk{}, &CallChunk{}, &ImportChunk{},
+  &FromImportChunk{},
+)
+func makeChunkTypes(chunks ...Renderable) map[string]reflect.Type {
+  res := make(map[string]reflect.Type)
+  for _, chunk := range chunks {
+    t := reflect.TypeOf(chunk).Elem()
+    res[t.Name()] = t
+  }
+  return res
+}
+
+func (self RenderableList) MarshalJSON() ([]byte, error) {
+  encoded := make([]encodedChunk, len(self))
+  for idx, chunk := range self {
+    t := reflect.TypeOf(chunk).Elem()
+    if _, ok := chunk_types[t.Name()]; !ok {
+      return nil, errors.New("cannot serialize a chunk of type '" + t.Name() + "'")
+    }
+    data, err := json.Marshal(chunk)
+    if err != nil {
+      return nil, err
+    }
+    encoded[idx] = encodedChunk{t.Name(), data}
+  }
+  return json.Marshal(encoded)
+}
+
+func (self *RenderableList) UnmarshalJSON(data []byte) error {
+  var encoded []encodedChunk
+  if err := json.Unmarshal(data, &encoded); err != nil {
+    return err
+  }
+  res := make(RenderableList, len(encoded))
+  for idx, e := range encoded {
+    t, ok := chunk_types[e.Type]
+    if !ok {
+      return errors.New("unknown chunk type '" + e.Type + "'")
+    }
+    chunk := reflect.New(t).Interface().(Renderable)
+    if err := json.Unmarshal(e.Chunk, chunk); err != nil {
+      return err
+    }
+    res[idx] = chunk
+  }
+  *self = res
+  return nil
+}
+
+//-------------------------------------------------------------------------------------------------
+// The FileSystemBytecodeCache stores each template in its own file in
+// the directory. The file names come from the Pattern, where %s is
+// replaced with the key.
+type FileSystemBytecodeCache struct {
+  Directory string
+  Pattern string
+}
+
+// NewFileSystemBytecodeCache creates a cache in the directory, which
+// is created when needed. When the directory is empty, a directory in
+// the system's temporary directory is used.
+func NewFileSystemBytecodeCache(directory string) *FileSystemBytecodeCache {
+  if directory == "" {
+    directory = filepath.Join(os.TempDir(), "_jinja2-cache")
+  }
+  return &FileSystemBytecodeCache{directory, "__jinja2_%s.cache"}
+}
+
+func (self *FileSystemBytecodeCache) filename(key string) string {
+  return filepath.Join(self.Directory, fmt.Sprintf(self.Pattern, key))
+}
+
+func (self *FileSystemBytecodeCache) Load(key string) ([]byte, error) {
+  data, err := os.ReadFile(self.filename(key))
+  if os.IsNotExist(err) {
+    return nil, nil
+  }
+  return data, err
+}
+
+// Dump writes to a temporary file which is then renamed into place, so
+// that another process never sees a partially written file.
+func (self *FileSystemBytecodeCache) Dump(key string, data []byte) error {
+  if err := os.MkdirAll(self.Directory, 0700); err != nil {
+    return err
+  }
+  f, err := ioutil.TempFile(self.Directory, ".jinja2-cache-")
+  if err != nil {
+    return err
+  }
+  _, err = f.Write(data)
+  if close_err := f.Close(); err == nil {
+    err = close_err
+  }
+  if err == nil {
+    err = os.Rename(f.Name(), self.filename(key))
+  }
+  if err != nil {
+    os.Remove(f.Name())
+  }
+  return err
+}
+
+// Clear removes every template stored in the cache.
+func (self *FileSystemBytecodeCache) Clear() error {
+  matches, err := filepath.Glob(self.filename("*"))
+  if err != nil {
+    return err
+  }
+  for _, match := range matches {
+    if err := os.Remove(match); err != nil && !os.IsNotExist(err) {
+      return err
+    }
+  }
+  return nil
+}
@@ -0,0 +1,108 @@
+package jinja2
+
+import (
+  "testing"
+)
+
+type memoryBytecodeCache struct {
+  data map[string][]byte
+  loaded int
+  dumped int
+}
+func (self *memoryBytecodeCache) Load(key string) ([]byte, error) {
+  data, ok := self.data[key]
+  if ok {
+    self.loaded += 1
+  }
+  return data, nil
+}
+func (self *memoryBytecodeCache) Dump(key string, data []byte) error {
+  self.dumped += 1
+  self.data[key] = data
+  return nil
+}
+
+var bytecodeTemplates = map[string]string{
+  "base.html": "<title>{% block title %}base{% endblock %}</title>{% block body %}{% endblock %}",
+  "macros.html": "{% macro item(name, sep='') %}<li>{{ name }}{{ sep }}{{ caller() }}</li>{% endmacro %}",
+  "page.html": `{% extends "base.html" %}{% import "macros.html" as m %}` +
+    `{% block title %}{{ super() }}|page{% endblock %}` +
+    `{% block body %}{% for x in items if x != 0 %}{% call m.item(x) %}{{ loop.index }}{% endcall %}{% else %}none{% endfor %}` +
+    `{% set total %}{{ items }}{% endset %}{% with y = 0 %}{% if y %}a{% elif total %}{{ total }}{% else %}c{% endif %}{% endwith %}` +
+    `{% filter upper %}{{ '' }}done{% endfilter %}{% raw %}{{ raw }}{% endraw %}{% endblock %}`,
+}
+
+func TestBytecodeCache(t *testing.T) {
+  cache := &memoryBytecodeCache{data: make(map[string][]byte)}
+  vars := map[string]interface{}{"items": []interface{}{0, 1, 2}}
+  env := NewEnvironment(NewDictLoader(bytecodeTemplates))
+  env.BytecodeCache = cache
+  expected := renderFromEnv(t, env, "page.html", vars)
+  if cache.dumped != 3 || cache.loaded != 0 {
+    t.Errorf("expected 3 templates to be stored, got %d stored and %d loaded", cache.dumped, cache.loaded)
+  }
+  env = NewEnvironment(NewDictLoader(bytecodeTemplates))
+  env.BytecodeCache = cache
+  if res := renderFromEnv(t, env, "page.html", vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  if cache.dumped != 3 || cache.loaded != 3 {
+    t.Errorf("expected 3 templates to be loaded, got %d stored and %d loaded", cache.dumped, cache.loaded)
+  }
+}
+
+func TestBytecodeCacheOutOfDate(t *testing.T) {
+  cache := &memoryBytecodeCache{data: make(map[string][]byte)}
+  env := NewEnvironment(NewDictLoader(map[string]string{"index.html": "old"}))
+  env.BytecodeCache = cache
+  renderFromEnv(t, env, "index.html", nil)
+  // a changed source is parsed again
+  env = NewEnvironment(NewDictLoader(map[string]string{"index.html": "new"}))
+  env.BytecodeCache = cache
+  if res := renderFromEnv(t, env, "index.html", nil); res != "new" {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, "new")
+  }
+  // as is one parsed with different settings
+  env = NewEnvironment(NewDictLoader(map[string]string{"index.html": "new"}))
+  env.BytecodeCache = cache
+  env.KeepTrailingNewline = true
+  renderFromEnv(t, env, "index.html", nil)
+  if cache.loaded != 2 || cache.dumped != 3 {
+    t.Errorf("expected out of date templates to be parsed again, got %d stored and %d loaded", cache.dumped, cache.loaded)
+  }
+  // and a corrupt entry is ignored
+  for key, _ := range cache.data {
+    cache.data[key] = []byte("{")
+  }
+  env = NewEnvironment(NewDictLoader(map[string]string{"index.html": "new"}))
+  env.BytecodeCache = cache
+  if res := renderFromEnv(t, env, "index.html", nil); res != "new" {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, "new")
+  }
+}
+
+func TestFileSystemBytecodeCache(t *testing.T) {
+  cache := NewFileSystemBytecodeCache(t.TempDir())
+  if data, err := cache.Load("missing"); data != nil || err != nil {
+    t.Errorf("expected nothing to be loaded for a missing key, got: %v, %v", data, err)
+  }
+  env := NewEnvironment(NewDictLoader(bytecodeTemplates))
+  env.BytecodeCache = cache
+  vars := map[string]interface{}{"items": []interface{}{1}}
+  expected := renderFromEnv(t, env, "page.html", vars)
+  env = NewEnvironment(NewDictLoader(bytecodeTemplates))
+  env.BytecodeCache = cache
+  if res := renderFromEnv(t, env, "page.html", vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  key := bytecodeCacheKey("page.html", "")
+  if data, err := cache.Load(key); data == nil || err != nil {
+    t.Errorf("expected the template to be stored, got: %v", err)
+  }
+  if err := cache.Clear(); err != nil {
+    t.Fatalf("error clearing the cache: %v", err)
+  }
+  if data, err := cache.Load(key); data != nil || err != nil {
+    t.Errorf("expected the cache to be empty after clearing it, got: %v", err)
+  }
+}
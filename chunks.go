@@ -2,7 +2,11 @@ package jinja2
 
 import (
   "errors"
+  "io"
+  "regexp"
+  "sort"
   "strconv"
+  "strings"
   "github.com/alecthomas/participle"
   "github.com/alecthomas/participle/lexer"
 )
@@ -19,28 +23,36 @@ var (
     `|(?P<Int>[-+]?\d*)`+
     `|(?P<Keyword>or|and|is|in|not|if|elif|else)`,
 	)), "String")
+
+  // the names which let a macro take extra args or a caller
+  macro_special_re = regexp.MustCompile(`\b(varargs|kwargs|caller)\b`)
 )
 
+// A Renderable is a piece of a parsed template, which writes its
+// output directly to the writer as it renders.
 type Renderable interface {
-  Render(*Context) (string, error)
+  Render(io.Writer, *Context) error
 }
 
 type DummyChunk struct {
 }
-func (self *DummyChunk) Render(c *Context) (string, error) {
-  return "", nil
+func (self *DummyChunk) Render(w io.Writer, c *Context) error {
+  return nil
 }
 
 type TextChunk struct {
   Text string
 }
-func (self *TextChunk) Render(c *Context) (string, error) {
-  return self.Text, nil
+func (self *TextChunk) Render(w io.Writer, c *Context) error {
+  _, err := io.WriteString(w, self.Text)
+  return err
 }
 
 func VariableResToString(res VariableType) (string, error) {
   switch res.Type {
-  case PY_TYPE_STRING:
+  case PY_TYPE_UNDEFINED:
+    return AsUndefined(res).String()
+  case PY_TYPE_STRING, PY_TYPE_MARKUP:
     if v, ok := res.Data.(string); !ok {
       return "", errors.New("error converting string variable result to a string")
     } else {
@@ -62,13 +74,20 @@ func VariableResToString(res VariableType) (string, error) {
     if v, ok := res.Data.(float64); !ok {
       return "", errors.New("error converting float variable result to a string")
     } else {
-      return strconv.FormatFloat(v, 'f', -1, 64), nil
+      return floatToString(v), nil
     }
-  case PY_TYPE_LIST:
-    if v, ok := res.Data.([]VariableType); !ok {
+  case PY_TYPE_LIST, PY_TYPE_TUPLE:
+    if v, err := res.AsList(); err != nil {
       return "", errors.New("error converting list variable result to a string")
     } else {
-      res := "["
+      left, right := "[", "]"
+      if res.Type == PY_TYPE_TUPLE {
+        left, right = "(", ")"
+        if len(v) == 1 {
+          right = ",)"
+        }
+      }
+      res := left
       for idx, item := range v {
         item_str, err := VariableResToString(item)
         if err != nil {
@@ -79,7 +98,7 @@ func VariableResToString(res VariableType) (string, error) {
           res += ", "
         }
       }
-      res += "]"
+      res += right
       return res, nil
     }
   case PY_TYPE_DICT:
@@ -120,98 +139,86 @@ func VariableResToString(res VariableType) (string, error) {
   return "", errors.New("unknown type returned from variable statement ("+strconv.Itoa(int(res.Type))+"), cannot convert it to a string")
 }
 type VariableChunk struct {
+  SourceSpan
   VarAst *VariableStatement
 }
-func (self *VariableChunk) Render(c *Context) (string, error) {
+func (self *VariableChunk) Render(w io.Writer, c *Context) error {
   res, err := self.VarAst.Eval(c)
   if err != nil {
-    return "ERROR EVALUATING VARIABLE STATEMENT", err
+    return err
+  }
+  return writeValue(w, res, c)
+}
+// writeValue writes a value to the output, escaping it first when
+// autoescaping is on.
+func writeValue(w io.Writer, v VariableType, c *Context) error {
+  var err error
+  if c.autoescape {
+    v, err = Escape(v)
+    if err != nil {
+      return err
+    }
+  }
+  s, err := VariableResToString(v)
+  if err != nil {
+    return err
   }
-  return VariableResToString(res)
+  _, err = io.WriteString(w, s)
+  return err
 }
 
 type IfChunk struct {
+  SourceSpan
   IfAst *IfStatement
-  IfChunks []Renderable
+  IfChunks RenderableList
   ElifChunks []ElifChunk
-  ElseChunks []Renderable
+  ElseChunks RenderableList
 }
-func (self *IfChunk) Render(c *Context) (string, error) {
-  chunk_rendered := false
-  res := ""
+func (self *IfChunk) Render(w io.Writer, c *Context) error {
   v, err := self.IfAst.Eval(c)
   if err != nil {
-    // FIXME: error handling
-    return "ERROR EVALUATING IF STATEMENT", err
+    return err
   }
-  v_bool, ok := v.Data.(bool)
-  if !ok {
-    return "COULD NOT CONVERT IF RETURN TO A BOOLEAN", nil
+  v_bool, err := v.AsBool()
+  if err != nil {
+    return err
   }
   if v_bool {
-    for _, chunk := range self.IfChunks {
-      c_res, err := chunk.Render(c)
-      if err != nil {
-        return "", err
-      } else {
-        res = res + c_res
-      }
+    return RenderChunks(w, self.IfChunks, c)
+  }
+  for _, elif := range self.ElifChunks {
+    v, err := elif.ElifAst.Eval(c)
+    if err == nil {
+      v_bool, err = v.AsBool()
     }
-    chunk_rendered = true
-  } else {
-    for _, elif := range self.ElifChunks {
-      v, err := elif.ElifAst.Eval(c)
-      if err != nil {
-        // FIXME: error handling
-        return "ERROR EVALUATING ELIF STATEMENT", nil
-      }
-      v_bool, ok := v.Data.(bool)
-      if !ok {
-        return "COULD NOT CONVERT IF RETURN TO A BOOLEAN IN ELIF", nil
-      }
-      if v_bool {
-        for _, chunk := range elif.ElifChunks {
-          c_res, err := chunk.Render(c)
-          if err != nil {
-            return "", err
-          } else {
-            res = res + c_res
-          }
-        }
-        chunk_rendered = true
-        break
-      }
+    if err != nil {
+      // the error is from the elif tag rather than the if tag
+      return spanError(elif.SourceSpan, err, c)
     }
-  }
-  if !chunk_rendered {
-    for _, chunk := range self.ElseChunks {
-      c_res, err := chunk.Render(c)
-      if err != nil {
-        return "", err
-      } else {
-        res = res + c_res
-      }
+    if v_bool {
+      return RenderChunks(w, elif.ElifChunks, c)
     }
   }
-  return res, nil
+  return RenderChunks(w, self.ElseChunks, c)
 }
 
 type ElifChunk struct {
+  SourceSpan
   ElifAst    *ElifStatement
-  ElifChunks []Renderable
+  ElifChunks RenderableList
 }
 
 type ForChunk struct {
+  SourceSpan
   ForAst *ForStatement
   IfAst *IfStatement
-  Chunks []Renderable
-  ElseChunks []Renderable
+  Chunks RenderableList
+  ElseChunks RenderableList
 }
-func (self *ForChunk) Render(c *Context) (string, error) {
+func (self *ForChunk) Render(w io.Writer, c *Context) error {
   if len(self.ForAst.TargetList.Targets) == 0 {
-    return "ERROR EVALUATING FOR LOOP", errors.New("no targets found for assignment in the for loop")
+    return errors.New("no targets found for assignment in the for loop")
   }
-  res := ""
   did_loop := false
   num_tests := int64(len(self.ForAst.TestList.Tests))
   // FIXME: last_val would be used with the `loop.changed()` call,
@@ -224,13 +231,18 @@ func (self *ForChunk) Render(c *Context) (string, error) {
   if num_tests == 1 {
     test_res, err := self.ForAst.TestList.Tests[0].Eval(c)
     if err != nil {
-      return "ERROR EVALUATING FOR LOOP", err
+      return err
     }
     switch test_res.Type {
     // FIXME: handle other special cases
-    case PY_TYPE_LIST:
+    case PY_TYPE_UNDEFINED:
+      // undefined values loop as an empty list, unless strict
+      if AsUndefined(test_res).Policy == UNDEFINED_STRICT {
+        return AsUndefined(test_res).Error()
+      }
+    case PY_TYPE_LIST, PY_TYPE_TUPLE:
       // use the list as the list of items
-      v_list, _ := test_res.Data.([]VariableType)
+      v_list, _ := test_res.AsList()
       loop_items = append(loop_items, v_list...)
     case PY_TYPE_DICT:
       // use the list as the list of items
@@ -252,7 +264,7 @@ func (self *ForChunk) Render(c *Context) (string, error) {
     for _, test := range self.ForAst.TestList.Tests {
       test_res, err := test.Eval(c)
       if err != nil {
-        return "ERROR EVALUATING FOR LOOP", err
+        return err
       }
       loop_items = append(loop_items, test_res)
     }
@@ -262,17 +274,24 @@ func (self *ForChunk) Render(c *Context) (string, error) {
   num_tests = int64(len(loop_items))
 
   for idx, item := range loop_items {
+    if err := c.budget.iterate(); err != nil {
+      return err
+    }
+    // each iteration gets its own scope, so the loop variables and
+    // anything set inside the loop don't leak out of it
+    lc := c.derive()
     // set loop variables
     loop_vars := make(map[string]VariableType)
     loop_vars["index"] = VariableType{PY_TYPE_INT, int64(idx + 1)}
     loop_vars["index0"] = VariableType{PY_TYPE_INT, int64(idx)}
-    loop_vars["revindex"] = VariableType{PY_TYPE_INT, num_tests - int64(idx + 1)} // FIXME: not sure if this is right
-    loop_vars["revindex0"] = VariableType{PY_TYPE_INT, num_tests - int64(idx)}  // FIXME: not sure if this is right
+    loop_vars["revindex"] = VariableType{PY_TYPE_INT, num_tests - int64(idx)}
+    loop_vars["revindex0"] = VariableType{PY_TYPE_INT, num_tests - int64(idx) - 1}
     loop_vars["first"] = VariableType{PY_TYPE_BOOL, idx == 0}
-    loop_vars["last"] = VariableType{PY_TYPE_BOOL, int64(idx) == num_tests}
-    loop_vars["length"] = VariableType{PY_TYPE_BOOL, num_tests}
-    loop_vars["depth"] = VariableType{PY_TYPE_BOOL, int64(1)} // FIXME: recursive property
-    loop_vars["depth0"] = VariableType{PY_TYPE_BOOL, int64(0)} // FIXME: recursive property
+    loop_vars["last"] = VariableType{PY_TYPE_BOOL, int64(idx) == num_tests - 1}
+    loop_vars["length"] = VariableType{PY_TYPE_INT, num_tests}
+    // loops aren't recursive, so they're always at the top level
+    loop_vars["depth"] = VariableType{PY_TYPE_INT, int64(1)}
+    loop_vars["depth0"] = VariableType{PY_TYPE_INT, int64(0)}
     // FIXME: implement loop.cycle (callable)
     // FIXME: implement loop.changed (callable)
     // FIXME: implement loop() (callable)
@@ -286,74 +305,529 @@ func (self *ForChunk) Render(c *Context) (string, error) {
     } else {
       loop_vars["nextitem"] = VariableType{PY_TYPE_UNDEFINED, nil}
     }
-    c.Variables["loop"] = VariableType{PY_TYPE_DICT, loop_vars}
+    loop_dict := make(map[VariableType]VariableType)
+    for k, v := range loop_vars {
+      loop_dict[VariableType{PY_TYPE_STRING, k}] = v
+    }
+    lc.Variables["loop"] = VariableType{PY_TYPE_DICT, loop_dict}
     // map the test result to the expression list
-    target_len := len(self.ForAst.TargetList.Targets)
-    if target_len != 1 {
-      if item.Type != PY_TYPE_LIST {
-        return "Assignment Error", errors.New("Cannot assign a single value to multiple targets.")
-      }
-      v_list, _ := item.Data.([]VariableType)
-      item_len := len(v_list)
-      if item_len != target_len {
-        return "Assignment Error", errors.New("Cannot assign "+strconv.Itoa(item_len)+" values to "+strconv.Itoa(target_len)+" targets.")
-      } else {
-        for idx, target := range self.ForAst.TargetList.Targets {
-          c.Variables[*target.Name] = v_list[idx]
-        }
-      }
-    } else {
-      target := self.ForAst.TargetList.Targets[0]
-      c.Variables[*target.Name] = item
+    if err := self.ForAst.TargetList.Assign(item, lc); err != nil {
+      return err
     }
     do_loop := true
     if self.ForAst.IfStatement != nil {
-      if_res, err := self.ForAst.IfStatement.Eval(c)
+      if_res, err := self.ForAst.IfStatement.Eval(lc)
       if err != nil {
-        return "ERROR EVALUATING IF STATEMENT ON LOOP", err
+        return err
       }
       if_bool, err := if_res.AsBool()
       if err != nil {
-        return "ERROR CONVERTING IF RESULT ON LOOP TO BOOLEAN RESULT", err
+        return err
       }
       do_loop = if_bool
     }
     if do_loop {
       // render the main chunks
-      for _, chunk := range self.Chunks {
-        c_res, err := chunk.Render(c)
-        //print("CRES IS: "+c_res+"\n")
-        if err != nil {
-          return "", err
-        } else {
-          res = res + c_res
-        }
-        // mark the loop flag as true so we don't execute the else statement
-        did_loop = true
+      if err := RenderChunks(w, self.Chunks, lc); err != nil {
+        return err
       }
+      // mark the loop flag as true so we don't execute the else statement
+      did_loop = true
     }
-    // cleanup the loop variables from the context
-    delete(c.Variables, "loop")
   }
   if !did_loop {
     // render the else chunks
-    for _, chunk := range self.ElseChunks {
-      c_res, err := chunk.Render(c)
+    return RenderChunks(w, self.ElseChunks, c)
+  }
+  return nil
+}
+
+type SetChunk struct {
+  SourceSpan
+  SetAst *SetStatement
+  Chunks RenderableList
+}
+func (self *SetChunk) Render(w io.Writer, c *Context) error {
+  var value VariableType
+  if self.SetAst.Value != nil {
+    v, err := self.SetAst.Value.Eval(c)
+    if err != nil {
+      return err
+    }
+    value = v
+  } else {
+    // a block set captures the rendered body, optionally passed
+    // through a filter chain
+    res, err := captureChunks(self.Chunks, c)
+    if err != nil {
+      return err
+    }
+    value = c.rendered(res)
+    if self.SetAst.Filters != nil {
+      value, err = ProcessJ2Filters(value, self.SetAst.Filters, c)
       if err != nil {
-        return "", err
-      } else {
-        res = res + c_res
+        return err
+      }
+    }
+  }
+  if err := self.SetAst.TargetList.Assign(value, c); err != nil {
+    return err
+  }
+  return nil
+}
+
+type FilterChunk struct {
+  SourceSpan
+  FilterAst *FilterStatement
+  Chunks RenderableList
+}
+func (self *FilterChunk) Render(w io.Writer, c *Context) error {
+  res, err := captureChunks(self.Chunks, c)
+  if err != nil {
+    return err
+  }
+  value, err := ProcessJ2Filters(c.rendered(res), self.FilterAst.Filters, c)
+  if err != nil {
+    return err
+  }
+  return writeValue(w, value, c)
+}
+
+type AutoescapeChunk struct {
+  SourceSpan
+  AutoescapeAst *AutoescapeStatement
+  Chunks RenderableList
+}
+func (self *AutoescapeChunk) Render(w io.Writer, c *Context) error {
+  v, err := self.AutoescapeAst.Value.Eval(c)
+  if err != nil {
+    return err
+  }
+  enabled, err := v.AsBool()
+  if err != nil {
+    return err
+  }
+  ac := c.derive()
+  ac.autoescape = enabled
+  return RenderChunks(w, self.Chunks, ac)
+}
+
+type WithChunk struct {
+  SourceSpan
+  WithAst *WithStatement
+  Chunks RenderableList
+}
+func (self *WithChunk) Render(w io.Writer, c *Context) error {
+  // the values are all evaluated in the outer scope before any of
+  // them are set, then the body renders in a new scope
+  wc := c.derive()
+  for _, assignment := range self.WithAst.Assignments {
+    v, err := assignment.Value.Eval(c)
+    if err != nil {
+      return err
+    }
+    wc.Variables[*assignment.Name] = v
+  }
+  return RenderChunks(w, self.Chunks, wc)
+}
+
+type ExtendsChunk struct {
+  SourceSpan
+  ExtendsAst *ExtendsStatement
+}
+func (self *ExtendsChunk) Render(w io.Writer, c *Context) error {
+  if c.state.parent != nil {
+    return errors.New("extended multiple times")
+  }
+  v, err := self.ExtendsAst.Eval(c)
+  if err != nil {
+    return err
+  }
+  name, err := v.AsString()
+  if err != nil {
+    return errors.New("the template to extend must be given as a string")
+  }
+  if c.state.env == nil {
+    return errors.New("cannot extend '" + name + "' without an environment to load it from")
+  }
+  parent, err := c.state.env.GetTemplate(name)
+  if err != nil {
+    return err
+  }
+  c.state.parent = parent
+  return nil
+}
+
+type BlockChunk struct {
+  SourceSpan
+  BlockAst *BlockStatement
+  Chunks RenderableList
+  template *Template
+}
+func (self *BlockChunk) Render(w io.Writer, c *Context) error {
+  if c.state.parent != nil {
+    // the template extends another, so the block is only rendered
+    // where the parent template places it
+    return nil
+  }
+  // blocks only see the template level variables, unless they are
+  // scoped, in which case they also see the variables around them
+  base := c.state.root
+  if self.BlockAst.HasModifier("scoped") {
+    base = c
+  }
+  if err := RenderBlock(w, base, *self.BlockAst.Name, 0); err != nil {
+    return leavingFrame(err, "block '" + *self.BlockAst.Name + "'")
+  }
+  return nil
+}
+// RenderBlock renders the block definition at the given depth in the
+// inheritance chain, where 0 is the child-most definition. Inside the
+// block, super() renders the next definition up the chain.
+func RenderBlock(w io.Writer, c *Context, name string, depth int) error {
+  stack := c.state.blocks[name]
+  if depth >= len(stack) {
+    return errors.New("there is no parent block called '" + name + "'")
+  }
+  block := stack[depth]
+  if block.BlockAst.HasModifier("required") {
+    return errors.New("required block '" + name + "' not found")
+  }
+  if err := c.budget.enter(); err != nil {
+    return err
+  }
+  defer c.budget.leave()
+  bc := c.derive()
+  bc.template = block.template
+  bc.PyCalls["super"] = PyCallable{
+    func(args []VariableType) (VariableType, error) {
+      var res strings.Builder
+      if err := RenderBlock(c.captureWriter(&res), c, name, depth + 1); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, leavingFrame(err, "block '" + name + "'")
       }
+      return c.rendered(res.String()), nil
+    }, []CallableArg{},
+  }
+  return RenderChunks(w, block.Chunks, bc)
+}
+
+type IncludeChunk struct {
+  SourceSpan
+  IncludeAst *IncludeStatement
+}
+func (self *IncludeChunk) Render(w io.Writer, c *Context) error {
+  v, err := self.IncludeAst.Eval(c)
+  if err != nil {
+    return err
+  }
+  // either a single template name, or a list of names where the
+  // first one which exists is used
+  names := make([]string, 0)
+  switch v.Type {
+  case PY_TYPE_STRING:
+    name, _ := v.AsString()
+    names = append(names, name)
+  case PY_TYPE_LIST:
+    v_list, _ := v.Data.([]VariableType)
+    for _, item := range v_list {
+      name, err := item.AsString()
+      if err != nil {
+        return errors.New("the templates to include must be given as strings")
+      }
+      names = append(names, name)
+    }
+  default:
+    return errors.New("the template to include must be given as a string or a list of strings")
+  }
+  if c.state.env == nil {
+    return errors.New("cannot include '" + strings.Join(names, "', '") + "' without an environment to load it from")
+  }
+  var included *Template = nil
+  for _, name := range names {
+    t, err := c.state.env.GetTemplate(name)
+    if err == nil {
+      included = t
+      break
+    } else if !IsTemplateNotFound(err) {
+      return err
+    }
+  }
+  if included == nil {
+    if self.IncludeAst.IgnoreMissing {
+      return nil
     }
+    return &TemplateNotFoundError{strings.Join(names, ", ")}
+  }
+  if err := c.budget.enter(); err != nil {
+    return err
+  }
+  defer c.budget.leave()
+  if self.IncludeAst.WithContext() {
+    err = included.RenderTo(w, c)
+  } else {
+    err = included.RenderTo(w, c.isolated())
+  }
+  if err != nil {
+    return leavingFrame(err, "include '" + included.Name + "'")
   }
-  return res, nil
+  return nil
+}
+
+// A macro only takes more args than it has parameters when its body
+// uses varargs or kwargs, and only takes a caller when its body uses
+// caller, which is worked out when the macro is parsed.
+type MacroChunk struct {
+  SourceSpan
+  MacroAst *MacroStatement
+  Chunks RenderableList
+  CatchVarargs, CatchKwargs, Caller bool
+}
+func (self *MacroChunk) Render(w io.Writer, c *Context) error {
+  // defining a macro assigns it like a variable, and it renders with
+  // the context it was defined in rather than the one it's called from
+  c.Variables[*self.MacroAst.Name] = VariableType{PY_TYPE_CALLABLE, self.MakeCallable(c)}
+  return nil
+}
+func (self *MacroChunk) MakeCallable(c *Context) PyCallable {
+  return PyCallable{
+    func(args []VariableType) (VariableType, error) {
+      mc := c.derive()
+      pos_args, _ := args[0].Data.([]VariableType)
+      named_args, _ := args[1].Data.(map[VariableType]VariableType)
+      if err := self.checkArgs(pos_args, named_args); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      caller_key := VariableType{PY_TYPE_STRING, "caller"}
+      if caller, ok := named_args[caller_key]; ok {
+        mc.Variables["caller"] = caller
+        delete(named_args, caller_key)
+      }
+      if err := BindMacroArgs(self.MacroAst.Params, pos_args, named_args, mc); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      if err := mc.budget.enter(); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      defer mc.budget.leave()
+      res, err := captureChunks(self.Chunks, mc)
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, leavingFrame(err, "macro '" + *self.MacroAst.Name + "'")
+      }
+      return mc.rendered(res), nil
+    }, []CallableArg {
+      {"*varargs", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"**kwargs", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+}
+
+// checkArgs returns an error for the args of a call which the macro
+// has nowhere to put.
+func (self *MacroChunk) checkArgs(pos_args []VariableType, named_args map[VariableType]VariableType) error {
+  name := *self.MacroAst.Name
+  params := self.MacroAst.Params
+  if !self.CatchVarargs && len(pos_args) > len(params) {
+    return errors.New("macro '" + name + "' takes not more than " + strconv.Itoa(len(params)) + " argument(s)")
+  }
+  unexpected := make([]string, 0)
+  for k, _ := range named_args {
+    arg_name, _ := k.Data.(string)
+    if arg_name == "caller" {
+      if !self.Caller {
+        unexpected = append(unexpected, arg_name)
+      }
+      continue
+    }
+    found := false
+    for _, param := range params {
+      if *param.Name == arg_name {
+        found = true
+        break
+      }
+    }
+    if !found && !self.CatchKwargs {
+      unexpected = append(unexpected, arg_name)
+    }
+  }
+  if len(unexpected) > 0 {
+    sort.Strings(unexpected)
+    return errors.New("macro '" + name + "' takes no keyword argument '" + unexpected[0] + "'")
+  }
+  return nil
+}
+
+type CallChunk struct {
+  SourceSpan
+  CallAst *CallStatement
+  Chunks RenderableList
+}
+func (self *CallChunk) Render(w io.Writer, c *Context) error {
+  // the body of the call block is passed to the macro as `caller`
+  caller := PyCallable{
+    func(args []VariableType) (VariableType, error) {
+      cc := c.derive()
+      pos_args, _ := args[0].Data.([]VariableType)
+      named_args, _ := args[1].Data.(map[VariableType]VariableType)
+      if err := BindMacroArgs(self.CallAst.Params, pos_args, named_args, cc); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      if err := cc.budget.enter(); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      defer cc.budget.leave()
+      res, err := captureChunks(self.Chunks, cc)
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, leavingFrame(err, "call block")
+      }
+      return cc.rendered(res), nil
+    }, []CallableArg {
+      {"*varargs", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"**kwargs", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  res, err := self.CallAst.Call.EvalWithArgs(c, []CallableArg{{"caller", VariableType{PY_TYPE_CALLABLE, caller}}})
+  if err != nil {
+    return err
+  }
+  return writeValue(w, res, c)
+}
+
+// ImportModule loads the named template and makes a module from it,
+// rendering it with the current context only when asked to.
+func ImportModule(v VariableType, with_context bool, c *Context) (*TemplateModule, error) {
+  name, err := v.AsString()
+  if err != nil {
+    return nil, errors.New("the template to import must be given as a string")
+  }
+  if c.state.env == nil {
+    return nil, errors.New("cannot import '" + name + "' without an environment to load it from")
+  }
+  t, err := c.state.env.GetTemplate(name)
+  if err != nil {
+    return nil, err
+  }
+  if err := c.budget.enter(); err != nil {
+    return nil, err
+  }
+  defer c.budget.leave()
+  var module *TemplateModule
+  if with_context {
+    module, err = t.MakeModule(c)
+  } else {
+    module, err = t.MakeModule(c.isolated())
+  }
+  if err != nil {
+    return nil, leavingFrame(err, "import '" + name + "'")
+  }
+  return module, nil
+}
+
+type ImportChunk struct {
+  SourceSpan
+  ImportAst *ImportStatement
+}
+func (self *ImportChunk) Render(w io.Writer, c *Context) error {
+  v, err := self.ImportAst.Eval(c)
+  if err != nil {
+    return err
+  }
+  module, err := ImportModule(v, self.ImportAst.WithContext(), c)
+  if err != nil {
+    return err
+  }
+  c.Variables[*self.ImportAst.Alias] = VariableType{PY_TYPE_MODULE, module}
+  return nil
+}
+
+type FromImportChunk struct {
+  SourceSpan
+  FromImportAst *FromImportStatement
+}
+func (self *FromImportChunk) Render(w io.Writer, c *Context) error {
+  v, err := self.FromImportAst.Eval(c)
+  if err != nil {
+    return err
+  }
+  module, err := ImportModule(v, self.FromImportAst.WithContext(), c)
+  if err != nil {
+    return err
+  }
+  for _, import_name := range self.FromImportAst.Names {
+    name := *import_name.Name
+    if strings.HasPrefix(name, "_") {
+      return errors.New("names starting with an underscore can not be imported")
+    }
+    v, ok := module.Exports[name]
+    if !ok {
+      return errors.New("the template '" + module.Name + "' does not export the requested name '" + name + "'")
+    }
+    if import_name.Alias != nil {
+      name = *import_name.Alias
+    }
+    c.Variables[name] = v
+  }
+  return nil
 }
 
 type RawChunk struct {
   Content string
 }
-func (self *RawChunk) Render(c *Context) (string, error) {
-  return self.Content, nil
+func (self *RawChunk) Render(w io.Writer, c *Context) error {
+  _, err := io.WriteString(w, self.Content)
+  return err
+}
+
+// SubChunks returns all of the chunks nested inside of a chunk.
+func SubChunks(chunk Renderable) []Renderable {
+  res := make([]Renderable, 0)
+  switch v := chunk.(type) {
+  case *IfChunk:
+    res = append(res, v.IfChunks...)
+    for _, elif := range v.ElifChunks {
+      res = append(res, elif.ElifChunks...)
+    }
+    res = append(res, v.ElseChunks...)
+  case *ForChunk:
+    res = append(res, v.Chunks...)
+    res = append(res, v.ElseChunks...)
+  case *BlockChunk:
+    res = append(res, v.Chunks...)
+  case *MacroChunk:
+    res = append(res, v.Chunks...)
+  case *CallChunk:
+    res = append(res, v.Chunks...)
+  case *SetChunk:
+    res = append(res, v.Chunks...)
+  case *FilterChunk:
+    res = append(res, v.Chunks...)
+  case *AutoescapeChunk:
+    res = append(res, v.Chunks...)
+  case *WithChunk:
+    res = append(res, v.Chunks...)
+  }
+  return res
+}
+
+// RenderChunks renders each of the chunks in order to the writer.
+func RenderChunks(w io.Writer, chunks []Renderable, c *Context) error {
+  for _, chunk := range chunks {
+    if err := c.budget.check(); err != nil {
+      return err
+    }
+    if err := chunk.Render(w, c); err != nil {
+      return chunkError(chunk, err, c)
+    }
+  }
+  return nil
+}
+// captureChunks renders the chunks to a string, for the tags which use
+// their body as a value rather than writing it out.
+func captureChunks(chunks []Renderable, c *Context) (string, error) {
+  var res strings.Builder
+  if err := RenderChunks(c.captureWriter(&res), chunks, c); err != nil {
+    return "", err
+  }
+  return res.String(), nil
 }
 
 func ParseBlocks(tokens []Token, pos int, inside string) (int, []Renderable, error) {
@@ -396,19 +870,138 @@ func ParseBlocks(tokens []Token, pos int, inside string) (int, []Renderable, err
       if inside == "if" {
         stop_parsing = true
       } else {
-        return cur_pos, nil, errors.New("invalid token found: '" + res + "' but not currently inside an if statement")
+        return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "invalid token found: '" + res + "' but not currently inside an if statement")
       }
     case "endfor":
       if inside == "for" {
         stop_parsing = true
       } else {
-        return cur_pos, nil, errors.New("invalid token found: '" + res + "' but not currently inside a for statement")
+        return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "invalid token found: '" + res + "' but not currently inside a for statement")
       }
     case "else":
       if inside == "for" || inside == "if" {
         stop_parsing = true
       } else {
-        return cur_pos, nil, errors.New("invalid token found: '" + res + "' but not currently inside an if or a for statement")
+        return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "invalid token found: '" + res + "' but not currently inside an if or a for statement")
+      }
+    case "extends":
+      new_pos, extends_chunk, err := ParseExtends(tokens, cur_pos)
+      if err != nil {
+        return cur_pos, nil, err
+      }
+      contained_chunks = append(contained_chunks, extends_chunk)
+      cur_pos = new_pos
+    case "block":
+      new_pos, block_chunk, err := ParseBlock(tokens, cur_pos)
+      if err != nil {
+        return cur_pos, nil, err
+      }
+      contained_chunks = append(contained_chunks, block_chunk)
+      cur_pos = new_pos
+    case "endblock":
+      if inside == "block" {
+        stop_parsing = true
+      } else {
+        return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "invalid token found: '" + res + "' but not currently inside a block")
+      }
+    case "include":
+      new_pos, include_chunk, err := ParseInclude(tokens, cur_pos)
+      if err != nil {
+        return cur_pos, nil, err
+      }
+      contained_chunks = append(contained_chunks, include_chunk)
+      cur_pos = new_pos
+    case "macro":
+      new_pos, macro_chunk, err := ParseMacro(tokens, cur_pos)
+      if err != nil {
+        return cur_pos, nil, err
+      }
+      contained_chunks = append(contained_chunks, macro_chunk)
+      cur_pos = new_pos
+    case "endmacro":
+      if inside == "macro" {
+        stop_parsing = true
+      } else {
+        return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "invalid token found: '" + res + "' but not currently inside a macro")
+      }
+    case "call":
+      new_pos, call_chunk, err := ParseCall(tokens, cur_pos)
+      if err != nil {
+        return cur_pos, nil, err
+      }
+      contained_chunks = append(contained_chunks, call_chunk)
+      cur_pos = new_pos
+    case "endcall":
+      if inside == "call" {
+        stop_parsing = true
+      } else {
+        return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "invalid token found: '" + res + "' but not currently inside a call block")
+      }
+    case "import":
+      new_pos, import_chunk, err := ParseImport(tokens, cur_pos)
+      if err != nil {
+        return cur_pos, nil, err
+      }
+      contained_chunks = append(contained_chunks, import_chunk)
+      cur_pos = new_pos
+    case "from":
+      new_pos, from_chunk, err := ParseFromImport(tokens, cur_pos)
+      if err != nil {
+        return cur_pos, nil, err
+      }
+      contained_chunks = append(contained_chunks, from_chunk)
+      cur_pos = new_pos
+    case "set":
+      new_pos, set_chunk, err := ParseSet(tokens, cur_pos)
+      if err != nil {
+        return cur_pos, nil, err
+      }
+      contained_chunks = append(contained_chunks, set_chunk)
+      cur_pos = new_pos
+    case "endset":
+      if inside == "set" {
+        stop_parsing = true
+      } else {
+        return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "invalid token found: '" + res + "' but not currently inside a block set")
+      }
+    case "filter":
+      new_pos, filter_chunk, err := ParseFilter(tokens, cur_pos)
+      if err != nil {
+        return cur_pos, nil, err
+      }
+      contained_chunks = append(contained_chunks, filter_chunk)
+      cur_pos = new_pos
+    case "endfilter":
+      if inside == "filter" {
+        stop_parsing = true
+      } else {
+        return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "invalid token found: '" + res + "' but not currently inside a filter block")
+      }
+    case "autoescape":
+      new_pos, autoescape_chunk, err := ParseAutoescape(tokens, cur_pos)
+      if err != nil {
+        return cur_pos, nil, err
+      }
+      contained_chunks = append(contained_chunks, autoescape_chunk)
+      cur_pos = new_pos
+    case "endautoescape":
+      if inside == "autoescape" {
+        stop_parsing = true
+      } else {
+        return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "invalid token found: '" + res + "' but not currently inside an autoescape block")
+      }
+    case "with":
+      new_pos, with_chunk, err := ParseWith(tokens, cur_pos)
+      if err != nil {
+        return cur_pos, nil, err
+      }
+      contained_chunks = append(contained_chunks, with_chunk)
+      cur_pos = new_pos
+    case "endwith":
+      if inside == "with" {
+        stop_parsing = true
+      } else {
+        return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "invalid token found: '" + res + "' but not currently inside a with block")
       }
     case "raw":
       new_pos, raw_chunk, err := ParseRaw(tokens, cur_pos)
@@ -418,22 +1011,58 @@ func ParseBlocks(tokens []Token, pos int, inside string) (int, []Renderable, err
       contained_chunks = append(contained_chunks, raw_chunk)
       cur_pos = new_pos
     default:
-      return cur_pos, nil, errors.New("invalid token found: '" + res + "'")
+      return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "invalid token found: '" + res + "'")
+    }
+    if stop_parsing {
+      // the callers handle the tags which end their blocks themselves,
+      // so stopping on the first tag means it can't be used where it
+      // is, as with an 'else' following an 'else'
+      if cur_pos == pos {
+        return cur_pos, nil, syntaxErrorAt(tokens[cur_pos], "unexpected '" + res + "' tag found.")
+      }
+      break
     }
-    if stop_parsing { break }
   }
   //fmt.Println("DONE PARSING BLOCKS", cur_pos)
   return cur_pos, contained_chunks, nil
 }
+// syntaxErrorAt creates a syntax error pointing at the start of a tag.
+func syntaxErrorAt(token Token, message string) error {
+  base := token.Base()
+  return &TemplateSyntaxError{Message: message, Line: base.Line, Column: base.Column}
+}
+// statementError converts an error from parsing the statement inside
+// of a tag into a syntax error, moving the position participle gives
+// (which is relative to the statement) to the position in the whole
+// template.
+func statementError(token Token, err error) error {
+  base := token.Base()
+  var parse_err participle.Error
+  if !errors.As(err, &parse_err) {
+    column := base.Column + base.ContentPos - base.Pos
+    return &TemplateSyntaxError{Message: err.Error(), Line: base.Line, Column: column}
+  }
+  pos := parse_err.Position()
+  message := strings.TrimPrefix(err.Error(), strconv.Itoa(pos.Line) + ":" + strconv.Itoa(pos.Column) + ": ")
+  line := base.Line
+  column := base.Column + base.ContentPos - base.Pos
+  if pos.Line > 1 {
+    line += pos.Line - 1
+    column = pos.Column
+  } else if pos.Column > 0 {
+    column += pos.Column - 1
+  }
+  return &TemplateSyntaxError{Message: message, Line: line, Column: column}
+}
 func ParseRaw(tokens []Token, pos int) (int, Renderable, error) {
   cur_pos := pos
   if res := PeekToken(tokens[cur_pos]); res != "raw" {
-    return cur_pos, &DummyChunk{}, errors.New("expected a raw token, found '" + res + "' instead")
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a raw token, found '" + res + "' instead")
   }
   raw_token := tokens[cur_pos].(RawToken)
   cur_pos += 1
   if res := PeekToken(tokens[cur_pos]); res != "endraw" {
-    return cur_pos, &DummyChunk{}, errors.New("expected a endraw token, found '" + res + "' instead")
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a endraw token, found '" + res + "' instead")
   }
   raw_chunk := new(RawChunk)
   raw_chunk.Content = raw_token.Content
@@ -443,7 +1072,7 @@ func ParseText(tokens []Token, pos int) (int, Renderable, error) {
   //fmt.Println("PARSING TEXT", pos)
   cur_pos := pos
   if res := PeekToken(tokens[cur_pos]); res != "text" {
-    return cur_pos, &DummyChunk{}, errors.New("expected a text token, found '" + res + "' instead")
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a text token, found '" + res + "' instead")
   } else {
     //fmt.Println("DONE PARSING TEXT")
     text_token := tokens[cur_pos].(TextToken)
@@ -454,14 +1083,11 @@ func ParseText(tokens []Token, pos int) (int, Renderable, error) {
 }
 func ParseVariableStatement(statement string) (*VariableStatement, error) {
   //fmt.Println("PARSING VARIABLE STATEMENT:", statement)
-  parser, err := participle.Build(&VariableStatement{}, PythonLexer)
+  res, err := variable_parser.parse(statement)
   if err != nil {
     return nil, err
   }
-  ast := &VariableStatement{}
-  if err := parser.ParseString(statement, ast); err != nil {
-    return nil, err
-  }
+  ast := res.(*VariableStatement)
   //fmt.Println("DONE PARSING VARIABLE STATEMENT")
   return ast, nil
 }
@@ -469,30 +1095,28 @@ func ParseVariable(tokens []Token, pos int) (int, Renderable, error) {
   //fmt.Println("PARSING VARIABLE", pos)
   cur_pos := pos
   if res := PeekToken(tokens[cur_pos]); res != "variable" {
-    return cur_pos, &DummyChunk{}, errors.New("expected a variable token, found '" + res + "' instead")
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a variable token, found '" + res + "' instead")
   } else {
     //fmt.Println("DONE PARSING VARIABLE")
     var_token := tokens[cur_pos].(VariableToken)
     var_chunk := new(VariableChunk)
     ast, err := ParseVariableStatement(var_token.Content)
     if err != nil {
-      return pos, &DummyChunk{}, err
+      return pos, &DummyChunk{}, statementError(var_token, err)
     }
     var_chunk.VarAst = ast
+    var_chunk.SourceSpan = tokenSpan(var_token, var_token.Content)
     return cur_pos+1, var_chunk, nil
   }
 }
 
 func ParseIfStatement(statement string) (*IfStatement, error) {
   //fmt.Println("PARSING IF:", statement)
-  parser, err := participle.Build(&IfStatement{}, PythonLexer)
+  res, err := if_parser.parse(statement)
   if err != nil {
     return nil, err
   }
-  ast := &IfStatement{}
-  if err := parser.ParseString(statement, ast); err != nil {
-    return nil, err
-  }
+  ast := res.(*IfStatement)
   return ast, nil
 }
 func ParseIf(tokens []Token, pos int) (int, Renderable, error) {
@@ -505,15 +1129,16 @@ func ParseIf(tokens []Token, pos int) (int, Renderable, error) {
 
   cur_pos := pos
   if res := PeekToken(tokens[cur_pos]); res != "if" {
-    return cur_pos, &DummyChunk{}, errors.New("expected an 'if' token but got '" + res + "' instead.")
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected an 'if' token but got '" + res + "' instead.")
   }
 
   if_token := tokens[cur_pos].(IfToken)
   ast, err := ParseIfStatement(if_token.IfStatement)
   if err != nil {
-    return pos, &DummyChunk{}, err
+    return pos, &DummyChunk{}, statementError(if_token, err)
   }
   if_chunk.IfAst = ast
+  if_chunk.SourceSpan = tokenSpan(if_token, if_token.IfStatement)
   cur_pos += 1
 
   found_endif := false
@@ -549,21 +1174,18 @@ func ParseIf(tokens []Token, pos int) (int, Renderable, error) {
   }
   if !found_endif {
     //fmt.Println("NO ENDIF!!!!")
-    return cur_pos, &DummyChunk{}, errors.New("Missing 'endif' for if statement tag.")
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[pos], "Missing 'endif' for if statement tag.")
   }
   //fmt.Println("DONE PARSING IF STATEMENT")
   return cur_pos, if_chunk, nil
 }
 
 func ParseElifStatement(statement string) (*ElifStatement, error) {
-  parser, err := participle.Build(&ElifStatement{}, PythonLexer)
+  res, err := elif_parser.parse(statement)
   if err != nil {
     return nil, err
   }
-  ast := &ElifStatement{}
-  if err := parser.ParseString(statement, ast); err != nil {
-    return nil, err
-  }
+  ast := res.(*ElifStatement)
   return ast, nil
 }
 func ParseElif(tokens []Token, pos int) (int, ElifChunk, error) {
@@ -574,14 +1196,15 @@ func ParseElif(tokens []Token, pos int) (int, ElifChunk, error) {
 
   cur_pos := pos
   if res := PeekToken(tokens[cur_pos]); res != "elif" {
-    return cur_pos, *elif_chunk, errors.New("expected an 'elif' token but got '" + res + "' instead.")
+    return cur_pos, *elif_chunk, syntaxErrorAt(tokens[cur_pos], "expected an 'elif' token but got '" + res + "' instead.")
   }
   elif_token := tokens[cur_pos].(ElifToken)
   ast, err := ParseElifStatement(elif_token.ElifStatement)
   if err != nil {
-    return pos, *elif_chunk, err
+    return pos, *elif_chunk, statementError(elif_token, err)
   }
   elif_chunk.ElifAst = ast
+  elif_chunk.SourceSpan = tokenSpan(elif_token, elif_token.ElifStatement)
   cur_pos += 1
 
   found_stop := false
@@ -609,7 +1232,7 @@ func ParseElse(tokens []Token, pos int, in string) (int, []Renderable, error) {
   chunks := make([]Renderable, 0)
   cur_pos := pos
   if res := PeekToken(tokens[cur_pos]); res != "else" {
-    return cur_pos, chunks, errors.New("expected an 'else' token but got '" + res + "' instead.")
+    return cur_pos, chunks, syntaxErrorAt(tokens[cur_pos], "expected an 'else' token but got '" + res + "' instead.")
   }
   cur_pos += 1
 
@@ -617,17 +1240,19 @@ func ParseElse(tokens []Token, pos int, in string) (int, []Renderable, error) {
   for cur_pos < len(tokens) {
     res := PeekToken(tokens[cur_pos])
     switch res {
+    case "else", "elif":
+      return cur_pos, chunks, syntaxErrorAt(tokens[cur_pos], "unexpected '" + res + "' found after an 'else' tag.")
     case "endfor":
       if in == "for" {
         found_stop = true
       } else {
-        return cur_pos, chunks, errors.New("unexpected 'endfor' found when not in a for loop.")
+        return cur_pos, chunks, syntaxErrorAt(tokens[cur_pos], "unexpected 'endfor' found when not in a for loop.")
       }
     case "endif":
       if in == "if" {
         found_stop = true
       } else {
-        return cur_pos, chunks, errors.New("unexpected 'endif' found when not in an if statement.")
+        return cur_pos, chunks, syntaxErrorAt(tokens[cur_pos], "unexpected 'endif' found when not in an if statement.")
       }
     default:
       new_pos, contained_chunks, err := ParseBlocks(tokens, cur_pos, in)
@@ -644,14 +1269,11 @@ func ParseElse(tokens []Token, pos int, in string) (int, []Renderable, error) {
 }
 func ParseForStatement(statement string) (*ForStatement, error) {
   //fmt.Println("PARSING FOR STATEMENT:", statement)
-  parser, err := participle.Build(&ForStatement{}, PythonLexer)
+  res, err := for_parser.parse(statement)
   if err != nil {
     return nil, err
   }
-  ast := &ForStatement{}
-  if err := parser.ParseString(statement, ast); err != nil {
-    return nil, err
-  }
+  ast := res.(*ForStatement)
   //fmt.Println("DONE PARSING FOR STATEMENT")
   return ast, nil
 }
@@ -664,14 +1286,15 @@ func ParseFor(tokens []Token, pos int) (int, Renderable, error) {
 
   cur_pos := pos
   if res := PeekToken(tokens[cur_pos]); res != "for" {
-    return cur_pos, &DummyChunk{}, errors.New("expected a 'for' token but got '" + res + "' instead.")
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a 'for' token but got '" + res + "' instead.")
   }
   for_token := tokens[cur_pos].(ForToken)
   ast, err := ParseForStatement(for_token.ForStatement)
   if err != nil {
-    return pos, &DummyChunk{}, err
+    return pos, &DummyChunk{}, statementError(for_token, err)
   }
   for_chunk.ForAst = ast
+  for_chunk.SourceSpan = tokenSpan(for_token, for_token.ForStatement)
   cur_pos += 1
 
   found_endfor := false
@@ -699,7 +1322,482 @@ func ParseFor(tokens []Token, pos int) (int, Renderable, error) {
     if found_endfor { break }
   }
   if !found_endfor {
-    return cur_pos, &DummyChunk{}, errors.New("Missing matching 'endfor' for a 'for' loop tag.")
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[pos], "Missing matching 'endfor' for a 'for' loop tag.")
   }
   return cur_pos, for_chunk, nil
 }
+
+func ParseExtendsStatement(statement string) (*ExtendsStatement, error) {
+  res, err := extends_parser.parse(statement)
+  if err != nil {
+    return nil, err
+  }
+  ast := res.(*ExtendsStatement)
+  return ast, nil
+}
+func ParseExtends(tokens []Token, pos int) (int, Renderable, error) {
+  cur_pos := pos
+  if res := PeekToken(tokens[cur_pos]); res != "extends" {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected an 'extends' token but got '" + res + "' instead.")
+  }
+  extends_token := tokens[cur_pos].(ExtendsToken)
+  ast, err := ParseExtendsStatement(extends_token.ExtendsStatement)
+  if err != nil {
+    return pos, &DummyChunk{}, statementError(extends_token, err)
+  }
+  extends_chunk := new(ExtendsChunk)
+  extends_chunk.ExtendsAst = ast
+  extends_chunk.SourceSpan = tokenSpan(extends_token, extends_token.ExtendsStatement)
+  return cur_pos+1, extends_chunk, nil
+}
+
+func ParseBlockStatement(statement string) (*BlockStatement, error) {
+  res, err := block_parser.parse(statement)
+  if err != nil {
+    return nil, err
+  }
+  ast := res.(*BlockStatement)
+  return ast, nil
+}
+func ParseBlock(tokens []Token, pos int) (int, Renderable, error) {
+  block_chunk := new(BlockChunk)
+  block_chunk.BlockAst = nil
+  block_chunk.Chunks = make([]Renderable, 0)
+
+  cur_pos := pos
+  if res := PeekToken(tokens[cur_pos]); res != "block" {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a 'block' token but got '" + res + "' instead.")
+  }
+  block_token := tokens[cur_pos].(BlockToken)
+  ast, err := ParseBlockStatement(block_token.BlockStatement)
+  if err != nil {
+    return pos, &DummyChunk{}, statementError(block_token, err)
+  }
+  block_chunk.BlockAst = ast
+  block_chunk.SourceSpan = tokenSpan(block_token, block_token.BlockStatement)
+  cur_pos += 1
+
+  found_endblock := false
+  for cur_pos < len(tokens) {
+    res := PeekToken(tokens[cur_pos])
+    switch res {
+    case "endblock":
+      endblock_token := tokens[cur_pos].(EndblockToken)
+      if endblock_token.Name != "" && endblock_token.Name != *ast.Name {
+        return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "mismatched 'endblock' name '" + endblock_token.Name + "' for block '" + *ast.Name + "'")
+      }
+      found_endblock = true
+      cur_pos += 1
+    default:
+      new_pos, contained_chunks, err := ParseBlocks(tokens, cur_pos, "block")
+      if err != nil {
+        return cur_pos, &DummyChunk{}, err
+      }
+      block_chunk.Chunks = append(block_chunk.Chunks, contained_chunks...)
+      cur_pos = new_pos
+    }
+    if found_endblock { break }
+  }
+  if !found_endblock {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[pos], "Missing matching 'endblock' for block '" + *ast.Name + "'.")
+  }
+  if ast.HasModifier("required") {
+    // required blocks must be overridden, so they can't have content
+    for _, chunk := range block_chunk.Chunks {
+      text_chunk, ok := chunk.(*TextChunk)
+      if !ok || strings.TrimSpace(text_chunk.Text) != "" {
+        return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[pos], "required block '" + *ast.Name + "' can only contain whitespace")
+      }
+    }
+  }
+  return cur_pos, block_chunk, nil
+}
+
+func ParseIncludeStatement(statement string) (*IncludeStatement, error) {
+  res, err := include_parser.parse(statement)
+  if err != nil {
+    return nil, err
+  }
+  ast := res.(*IncludeStatement)
+  return ast, nil
+}
+func ParseInclude(tokens []Token, pos int) (int, Renderable, error) {
+  cur_pos := pos
+  if res := PeekToken(tokens[cur_pos]); res != "include" {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected an 'include' token but got '" + res + "' instead.")
+  }
+  include_token := tokens[cur_pos].(IncludeToken)
+  ast, err := ParseIncludeStatement(include_token.IncludeStatement)
+  if err != nil {
+    return pos, &DummyChunk{}, statementError(include_token, err)
+  }
+  include_chunk := new(IncludeChunk)
+  include_chunk.IncludeAst = ast
+  include_chunk.SourceSpan = tokenSpan(include_token, include_token.IncludeStatement)
+  return cur_pos+1, include_chunk, nil
+}
+
+func ParseMacroStatement(statement string) (*MacroStatement, error) {
+  res, err := macro_parser.parse(statement)
+  if err != nil {
+    return nil, err
+  }
+  ast := res.(*MacroStatement)
+  return ast, nil
+}
+func ParseMacro(tokens []Token, pos int) (int, Renderable, error) {
+  macro_chunk := new(MacroChunk)
+  macro_chunk.MacroAst = nil
+  macro_chunk.Chunks = make([]Renderable, 0)
+
+  cur_pos := pos
+  if res := PeekToken(tokens[cur_pos]); res != "macro" {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a 'macro' token but got '" + res + "' instead.")
+  }
+  macro_token := tokens[cur_pos].(MacroToken)
+  ast, err := ParseMacroStatement(macro_token.MacroStatement)
+  if err != nil {
+    return pos, &DummyChunk{}, statementError(macro_token, err)
+  }
+  macro_chunk.MacroAst = ast
+  macro_chunk.SourceSpan = tokenSpan(macro_token, macro_token.MacroStatement)
+  cur_pos += 1
+
+  found_endmacro := false
+  for cur_pos < len(tokens) {
+    res := PeekToken(tokens[cur_pos])
+    switch res {
+    case "endmacro":
+      found_endmacro = true
+      cur_pos += 1
+    default:
+      new_pos, contained_chunks, err := ParseBlocks(tokens, cur_pos, "macro")
+      if err != nil {
+        return cur_pos, &DummyChunk{}, err
+      }
+      macro_chunk.Chunks = append(macro_chunk.Chunks, contained_chunks...)
+      cur_pos = new_pos
+    }
+    if found_endmacro { break }
+  }
+  if !found_endmacro {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[pos], "Missing matching 'endmacro' for macro '" + *ast.Name + "'.")
+  }
+  for _, token := range tokens[pos + 1:cur_pos] {
+    for _, name := range macro_special_re.FindAllString(TokenStatement(token), -1) {
+      switch name {
+      case "varargs":
+        macro_chunk.CatchVarargs = true
+      case "kwargs":
+        macro_chunk.CatchKwargs = true
+      case "caller":
+        macro_chunk.Caller = true
+      }
+    }
+  }
+  return cur_pos, macro_chunk, nil
+}
+
+func ParseCallStatement(statement string) (*CallStatement, error) {
+  res, err := call_parser.parse(statement)
+  if err != nil {
+    return nil, err
+  }
+  ast := res.(*CallStatement)
+  trailers := ast.Call.Trailers
+  if len(trailers) == 0 || trailers[len(trailers)-1].ArgList == nil {
+    return nil, errors.New("a call block must end with a call to a macro")
+  }
+  return ast, nil
+}
+func ParseCall(tokens []Token, pos int) (int, Renderable, error) {
+  call_chunk := new(CallChunk)
+  call_chunk.CallAst = nil
+  call_chunk.Chunks = make([]Renderable, 0)
+
+  cur_pos := pos
+  if res := PeekToken(tokens[cur_pos]); res != "call" {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a 'call' token but got '" + res + "' instead.")
+  }
+  call_token := tokens[cur_pos].(CallToken)
+  ast, err := ParseCallStatement(call_token.CallStatement)
+  if err != nil {
+    return pos, &DummyChunk{}, statementError(call_token, err)
+  }
+  call_chunk.CallAst = ast
+  call_chunk.SourceSpan = tokenSpan(call_token, call_token.CallStatement)
+  cur_pos += 1
+
+  found_endcall := false
+  for cur_pos < len(tokens) {
+    res := PeekToken(tokens[cur_pos])
+    switch res {
+    case "endcall":
+      found_endcall = true
+      cur_pos += 1
+    default:
+      new_pos, contained_chunks, err := ParseBlocks(tokens, cur_pos, "call")
+      if err != nil {
+        return cur_pos, &DummyChunk{}, err
+      }
+      call_chunk.Chunks = append(call_chunk.Chunks, contained_chunks...)
+      cur_pos = new_pos
+    }
+    if found_endcall { break }
+  }
+  if !found_endcall {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[pos], "Missing matching 'endcall' for a 'call' block.")
+  }
+  return cur_pos, call_chunk, nil
+}
+
+func ParseImportStatement(statement string) (*ImportStatement, error) {
+  res, err := import_parser.parse(statement)
+  if err != nil {
+    return nil, err
+  }
+  ast := res.(*ImportStatement)
+  return ast, nil
+}
+func ParseImport(tokens []Token, pos int) (int, Renderable, error) {
+  cur_pos := pos
+  if res := PeekToken(tokens[cur_pos]); res != "import" {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected an 'import' token but got '" + res + "' instead.")
+  }
+  import_token := tokens[cur_pos].(ImportToken)
+  ast, err := ParseImportStatement(import_token.ImportStatement)
+  if err != nil {
+    return pos, &DummyChunk{}, statementError(import_token, err)
+  }
+  import_chunk := new(ImportChunk)
+  import_chunk.ImportAst = ast
+  import_chunk.SourceSpan = tokenSpan(import_token, import_token.ImportStatement)
+  return cur_pos+1, import_chunk, nil
+}
+
+func ParseFromImportStatement(statement string) (*FromImportStatement, error) {
+  res, err := from_import_parser.parse(statement)
+  if err != nil {
+    return nil, err
+  }
+  ast := res.(*FromImportStatement)
+  return ast, nil
+}
+func ParseFromImport(tokens []Token, pos int) (int, Renderable, error) {
+  cur_pos := pos
+  if res := PeekToken(tokens[cur_pos]); res != "from" {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a 'from' token but got '" + res + "' instead.")
+  }
+  from_token := tokens[cur_pos].(FromImportToken)
+  ast, err := ParseFromImportStatement(from_token.FromImportStatement)
+  if err != nil {
+    return pos, &DummyChunk{}, statementError(from_token, err)
+  }
+  from_chunk := new(FromImportChunk)
+  from_chunk.FromImportAst = ast
+  from_chunk.SourceSpan = tokenSpan(from_token, from_token.FromImportStatement)
+  return cur_pos+1, from_chunk, nil
+}
+
+func ParseSetStatement(statement string) (*SetStatement, error) {
+  res, err := set_parser.parse(statement)
+  if err != nil {
+    return nil, err
+  }
+  ast := res.(*SetStatement)
+  if ast.Value != nil && ast.Filters != nil {
+    return nil, errors.New("filters can only be used on a block set")
+  }
+  return ast, nil
+}
+func ParseSet(tokens []Token, pos int) (int, Renderable, error) {
+  set_chunk := new(SetChunk)
+  set_chunk.SetAst = nil
+  set_chunk.Chunks = make([]Renderable, 0)
+
+  cur_pos := pos
+  if res := PeekToken(tokens[cur_pos]); res != "set" {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a 'set' token but got '" + res + "' instead.")
+  }
+  set_token := tokens[cur_pos].(SetToken)
+  ast, err := ParseSetStatement(set_token.SetStatement)
+  if err != nil {
+    return pos, &DummyChunk{}, statementError(set_token, err)
+  }
+  set_chunk.SetAst = ast
+  set_chunk.SourceSpan = tokenSpan(set_token, set_token.SetStatement)
+  cur_pos += 1
+  if ast.Value != nil {
+    // a plain assignment, there is no body
+    return cur_pos, set_chunk, nil
+  }
+
+  found_endset := false
+  for cur_pos < len(tokens) {
+    res := PeekToken(tokens[cur_pos])
+    switch res {
+    case "endset":
+      found_endset = true
+      cur_pos += 1
+    default:
+      new_pos, contained_chunks, err := ParseBlocks(tokens, cur_pos, "set")
+      if err != nil {
+        return cur_pos, &DummyChunk{}, err
+      }
+      set_chunk.Chunks = append(set_chunk.Chunks, contained_chunks...)
+      cur_pos = new_pos
+    }
+    if found_endset { break }
+  }
+  if !found_endset {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[pos], "Missing matching 'endset' for a block set.")
+  }
+  return cur_pos, set_chunk, nil
+}
+
+func ParseWithStatement(statement string) (*WithStatement, error) {
+  res, err := with_parser.parse(statement)
+  if err != nil {
+    return nil, err
+  }
+  ast := res.(*WithStatement)
+  return ast, nil
+}
+func ParseWith(tokens []Token, pos int) (int, Renderable, error) {
+  with_chunk := new(WithChunk)
+  with_chunk.WithAst = nil
+  with_chunk.Chunks = make([]Renderable, 0)
+
+  cur_pos := pos
+  if res := PeekToken(tokens[cur_pos]); res != "with" {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a 'with' token but got '" + res + "' instead.")
+  }
+  with_token := tokens[cur_pos].(WithToken)
+  ast, err := ParseWithStatement(with_token.WithStatement)
+  if err != nil {
+    return pos, &DummyChunk{}, statementError(with_token, err)
+  }
+  with_chunk.WithAst = ast
+  with_chunk.SourceSpan = tokenSpan(with_token, with_token.WithStatement)
+  cur_pos += 1
+
+  found_endwith := false
+  for cur_pos < len(tokens) {
+    res := PeekToken(tokens[cur_pos])
+    switch res {
+    case "endwith":
+      found_endwith = true
+      cur_pos += 1
+    default:
+      new_pos, contained_chunks, err := ParseBlocks(tokens, cur_pos, "with")
+      if err != nil {
+        return cur_pos, &DummyChunk{}, err
+      }
+      with_chunk.Chunks = append(with_chunk.Chunks, contained_chunks...)
+      cur_pos = new_pos
+    }
+    if found_endwith { break }
+  }
+  if !found_endwith {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[pos], "Missing matching 'endwith' for a 'with' block.")
+  }
+  return cur_pos, with_chunk, nil
+}
+
+func ParseFilterStatement(statement string) (*FilterStatement, error) {
+  res, err := filter_parser.parse(statement)
+  if err != nil {
+    return nil, err
+  }
+  ast := res.(*FilterStatement)
+  return ast, nil
+}
+func ParseFilter(tokens []Token, pos int) (int, Renderable, error) {
+  filter_chunk := new(FilterChunk)
+  filter_chunk.FilterAst = nil
+  filter_chunk.Chunks = make([]Renderable, 0)
+
+  cur_pos := pos
+  if res := PeekToken(tokens[cur_pos]); res != "filter" {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected a 'filter' token but got '" + res + "' instead.")
+  }
+  filter_token := tokens[cur_pos].(FilterToken)
+  ast, err := ParseFilterStatement(filter_token.FilterStatement)
+  if err != nil {
+    return pos, &DummyChunk{}, statementError(filter_token, err)
+  }
+  filter_chunk.FilterAst = ast
+  filter_chunk.SourceSpan = tokenSpan(filter_token, filter_token.FilterStatement)
+  cur_pos += 1
+
+  found_endfilter := false
+  for cur_pos < len(tokens) {
+    res := PeekToken(tokens[cur_pos])
+    switch res {
+    case "endfilter":
+      found_endfilter = true
+      cur_pos += 1
+    default:
+      new_pos, contained_chunks, err := ParseBlocks(tokens, cur_pos, "filter")
+      if err != nil {
+        return cur_pos, &DummyChunk{}, err
+      }
+      filter_chunk.Chunks = append(filter_chunk.Chunks, contained_chunks...)
+      cur_pos = new_pos
+    }
+    if found_endfilter { break }
+  }
+  if !found_endfilter {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[pos], "Missing matching 'endfilter' for a filter block.")
+  }
+  return cur_pos, filter_chunk, nil
+}
+
+func ParseAutoescapeStatement(statement string) (*AutoescapeStatement, error) {
+  res, err := autoescape_parser.parse(statement)
+  if err != nil {
+    return nil, err
+  }
+  ast := res.(*AutoescapeStatement)
+  return ast, nil
+}
+func ParseAutoescape(tokens []Token, pos int) (int, Renderable, error) {
+  autoescape_chunk := new(AutoescapeChunk)
+  autoescape_chunk.AutoescapeAst = nil
+  autoescape_chunk.Chunks = make([]Renderable, 0)
+
+  cur_pos := pos
+  if res := PeekToken(tokens[cur_pos]); res != "autoescape" {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[cur_pos], "expected an 'autoescape' token but got '" + res + "' instead.")
+  }
+  autoescape_token := tokens[cur_pos].(AutoescapeToken)
+  ast, err := ParseAutoescapeStatement(autoescape_token.AutoescapeStatement)
+  if err != nil {
+    return pos, &DummyChunk{}, statementError(autoescape_token, err)
+  }
+  autoescape_chunk.AutoescapeAst = ast
+  autoescape_chunk.SourceSpan = tokenSpan(autoescape_token, autoescape_token.AutoescapeStatement)
+  cur_pos += 1
+
+  found_endautoescape := false
+  for cur_pos < len(tokens) {
+    res := PeekToken(tokens[cur_pos])
+    switch res {
+    case "endautoescape":
+      found_endautoescape = true
+      cur_pos += 1
+    default:
+      new_pos, contained_chunks, err := ParseBlocks(tokens, cur_pos, "autoescape")
+      if err != nil {
+        return cur_pos, &DummyChunk{}, err
+      }
+      autoescape_chunk.Chunks = append(autoescape_chunk.Chunks, contained_chunks...)
+      cur_pos = new_pos
+    }
+    if found_endautoescape { break }
+  }
+  if !found_endautoescape {
+    return cur_pos, &DummyChunk{}, syntaxErrorAt(tokens[pos], "Missing matching 'endautoescape' for an autoescape block.")
+  }
+  return cur_pos, autoescape_chunk, nil
+}
@@ -0,0 +1,882 @@
+package jinja2
+
+import (
+  "errors"
+  "math/rand"
+  "sort"
+  "strconv"
+  "strings"
+  "unicode/utf8"
+)
+
+//-------------------------------------------------------------------------------------------------
+// The collection filters work on anything which can be iterated: lists
+// and tuples, the keys of dicts, and the characters of strings. Go maps
+// have no order, so dicts are iterated in the order of their sorted keys
+// to keep the results the same from one render to the next. Pairs of
+// keys and values are lists, in the same way as looping over a dict.
+
+// iterItems returns the items of an iterable value. Undefined values
+// are empty, unless they are strict.
+func iterItems(v VariableType) ([]VariableType, error) {
+  switch v.Type {
+  case PY_TYPE_UNDEFINED:
+    if AsUndefined(v).Policy == UNDEFINED_STRICT {
+      return nil, AsUndefined(v).Error()
+    }
+    return []VariableType{}, nil
+  case PY_TYPE_LIST, PY_TYPE_TUPLE:
+    if items, err := v.AsList(); err == nil {
+      return items, nil
+    }
+  case PY_TYPE_DICT:
+    if _, ok := v.Data.(map[VariableType]VariableType); ok {
+      keys := make([]VariableType, 0)
+      for _, pair := range dictPairs(v) {
+        keys = append(keys, pair.Data.([]VariableType)[0])
+      }
+      return keys, nil
+    }
+  case PY_TYPE_STRING, PY_TYPE_MARKUP:
+    if s, ok := v.Data.(string); ok {
+      items := make([]VariableType, 0)
+      for _, r := range s {
+        items = append(items, VariableType{v.Type, string(r)})
+      }
+      return items, nil
+    }
+  }
+  return nil, errors.New("'" + PyTypeToString(v.Type) + "' object is not iterable")
+}
+
+// dictPairs returns the key and value pairs of a dict, sorted by key.
+func dictPairs(v VariableType) []VariableType {
+  d, _ := v.Data.(map[VariableType]VariableType)
+  keys := make([]VariableType, 0)
+  for k, _ := range d {
+    keys = append(keys, k)
+  }
+  sort.SliceStable(keys, func(i, j int) bool {
+    // keys which can't be compared are ordered by type, then as strings
+    if res, err := compareValues(keys[i], keys[j], true); err == nil {
+      return res < 0
+    } else if keys[i].Type != keys[j].Type {
+      return keys[i].Type < keys[j].Type
+    }
+    l, _ := VariableResToString(keys[i])
+    r, _ := VariableResToString(keys[j])
+    return l < r
+  })
+  pairs := make([]VariableType, len(keys))
+  for idx, k := range keys {
+    pairs[idx] = VariableType{PY_TYPE_LIST, []VariableType{k, d[k]}}
+  }
+  return pairs
+}
+
+// compareValues orders two values in the same way as python, returning
+// a negative number, zero or a positive number. Strings are compared
+// without regard to case unless case_sensitive is set.
+func compareValues(l VariableType, r VariableType, case_sensitive bool) (int, error) {
+  is_number := func(v VariableType) bool {
+    return v.Type == PY_TYPE_INT || v.Type == PY_TYPE_FLOAT || v.Type == PY_TYPE_BOOL
+  }
+  is_string := func(v VariableType) bool {
+    return v.Type == PY_TYPE_STRING || v.Type == PY_TYPE_MARKUP
+  }
+  switch {
+  case l.Type == PY_TYPE_UNDEFINED && r.Type == PY_TYPE_UNDEFINED:
+    // undefined values are equal to each other, so items missing the
+    // key they are sorted by stay together, unless they are strict
+    for _, v := range []VariableType{l, r} {
+      if AsUndefined(v).Policy == UNDEFINED_STRICT {
+        return 0, AsUndefined(v).Error()
+      }
+    }
+    return 0, nil
+  case l.Type == PY_TYPE_UNDEFINED || r.Type == PY_TYPE_UNDEFINED:
+    return 0, undefinedOperand(l, r)
+  case l.Type == PY_TYPE_INT && r.Type == PY_TYPE_INT:
+    l_val, r_val := l.Data.(int64), r.Data.(int64)
+    if l_val < r_val {
+      return -1, nil
+    } else if l_val > r_val {
+      return 1, nil
+    }
+    return 0, nil
+  case is_number(l) && is_number(r):
+    l_val, _ := l.AsFloat()
+    r_val, _ := r.AsFloat()
+    if l_val < r_val {
+      return -1, nil
+    } else if l_val > r_val {
+      return 1, nil
+    }
+    return 0, nil
+  case is_string(l) && is_string(r):
+    l_val, _ := l.AsString()
+    r_val, _ := r.AsString()
+    if !case_sensitive {
+      l_val, r_val = strings.ToLower(l_val), strings.ToLower(r_val)
+    }
+    return strings.Compare(l_val, r_val), nil
+  case (l.Type == PY_TYPE_LIST || l.Type == PY_TYPE_TUPLE) && l.Type == r.Type:
+    l_items, _ := l.AsList()
+    r_items, _ := r.AsList()
+    for idx := 0; idx < len(l_items) && idx < len(r_items); idx++ {
+      if res, err := compareValues(l_items[idx], r_items[idx], case_sensitive); err != nil || res != 0 {
+        return res, err
+      }
+    }
+    return len(l_items) - len(r_items), nil
+  case l.Type == PY_TYPE_NONE && r.Type == PY_TYPE_NONE:
+    return 0, nil
+  }
+  return 0, errors.New("'<' not supported between instances of '" + PyTypeToString(l.Type) + "' and '" + PyTypeToString(r.Type) + "'")
+}
+
+// itemAttribute looks up an attribute of an item for the filters which
+// take one. The attribute may be a dotted path, and parts of it which
+// are numbers index into lists. Each part is checked by the sandbox, and
+// a missing one follows the undefined policy, as if it had been looked
+// up in the template.
+func itemAttribute(c *Context, item VariableType, attribute string) (VariableType, error) {
+  for _, part := range strings.Split(attribute, ".") {
+    owner := item
+    found := false
+    switch item.Type {
+    case PY_TYPE_DICT:
+      d, _ := item.Data.(map[VariableType]VariableType)
+      if v, ok := d[VariableType{PY_TYPE_STRING, part}]; ok {
+        item, found = v, true
+      } else if n, err := strconv.ParseInt(part, 10, 64); err == nil {
+        item, found = d[VariableType{PY_TYPE_INT, n}]
+      }
+    case PY_TYPE_LIST, PY_TYPE_TUPLE:
+      items, _ := item.AsList()
+      if n, err := strconv.Atoi(part); err == nil && n >= 0 && n < len(items) {
+        item, found = items[n], true
+      } else if named, ok := item.Data.(NamedTuple); ok {
+        for idx, name := range named.Names {
+          if name == part {
+            item, found = named.Items[idx], true
+            break
+          }
+        }
+      }
+    case PY_TYPE_MODULE:
+      item, found = item.Data.(*TemplateModule).Exports[part]
+    }
+    if !found {
+      item = c.undefinedAttribute(owner, part)
+    }
+    if err := c.checkAttribute(owner, part, item); err != nil {
+      return VariableType{PY_TYPE_UNDEFINED, nil}, err
+    }
+    if !found {
+      return item, nil
+    }
+  }
+  return item, nil
+}
+
+// itemKey is what the items are compared by, which is the item itself
+// or one of its attributes.
+func itemKey(c *Context, item VariableType, attribute VariableType) (VariableType, error) {
+  if attribute.Type == PY_TYPE_NONE {
+    return item, nil
+  }
+  if n, ok := attribute.Data.(int64); ok {
+    return itemAttribute(c, item, strconv.FormatInt(n, 10))
+  }
+  attr, _ := VariableResToString(attribute)
+  return itemAttribute(c, item, attr)
+}
+
+// sortItems sorts the items by their keys, keeping items with equal
+// keys in the same order.
+func sortItems(items []VariableType, keys []VariableType, reverse bool, case_sensitive bool) ([]VariableType, error) {
+  idxs := make([]int, len(items))
+  for idx, _ := range idxs {
+    idxs[idx] = idx
+  }
+  var sort_err error
+  sort.SliceStable(idxs, func(i, j int) bool {
+    res, err := compareValues(keys[idxs[i]], keys[idxs[j]], case_sensitive)
+    if err != nil && sort_err == nil {
+      sort_err = err
+    }
+    if reverse {
+      return res > 0
+    }
+    return res < 0
+  })
+  if sort_err != nil {
+    return nil, sort_err
+  }
+  res := make([]VariableType, len(items))
+  for idx, item_idx := range idxs {
+    res[idx] = items[item_idx]
+  }
+  return res, nil
+}
+
+// minMaxItem returns the smallest item, or the largest when want is 1.
+func minMaxItem(args []VariableType, want int) (VariableType, error) {
+  c := args[0].Data.(*Context)
+  items, err := iterItems(args[1])
+  if err != nil {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, err
+  }
+  if len(items) == 0 {
+    return VariableType{PY_TYPE_UNDEFINED, Undefined{}}, nil
+  }
+  case_sensitive, err := args[2].AsBool()
+  if err != nil {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, err
+  }
+  best := items[0]
+  best_key, err := itemKey(c, best, args[3])
+  if err != nil {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, err
+  }
+  for _, item := range items[1:] {
+    key, err := itemKey(c, item, args[3])
+    if err != nil {
+      return VariableType{PY_TYPE_UNDEFINED, nil}, err
+    }
+    res, err := compareValues(key, best_key, case_sensitive)
+    if err != nil {
+      return VariableType{PY_TYPE_UNDEFINED, nil}, err
+    }
+    if res * want > 0 {
+      best, best_key = item, key
+    }
+  }
+  return best, nil
+}
+
+// sliceItems splits the items into the number of slices, with any
+// extra items going to the first slices.
+func sliceItems(items []VariableType, slices int, fill_with VariableType) []VariableType {
+  res := make([]VariableType, 0)
+  per_slice := len(items) / slices
+  with_extra := len(items) % slices
+  offset := 0
+  for n := 0; n < slices; n++ {
+    start := offset + n * per_slice
+    if n < with_extra {
+      offset += 1
+    }
+    end := offset + (n + 1) * per_slice
+    slice := append([]VariableType{}, items[start:end]...)
+    if fill_with.Type != PY_TYPE_NONE && n >= with_extra {
+      slice = append(slice, fill_with)
+    }
+    res = append(res, VariableType{PY_TYPE_LIST, slice})
+  }
+  return res
+}
+
+func addCollectionFilters(filters map[string]PyCallable) {
+  filters["first"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      items, err := iterItems(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      if len(items) == 0 {
+        return VariableType{PY_TYPE_UNDEFINED, Undefined{}}, nil
+      }
+      return items[0], nil
+    }, []CallableArg {
+      {"seq", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["last"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      items, err := iterItems(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      if len(items) == 0 {
+        return VariableType{PY_TYPE_UNDEFINED, Undefined{}}, nil
+      }
+      return items[len(items)-1], nil
+    }, []CallableArg {
+      {"seq", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["length"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      switch args[0].Type {
+      case PY_TYPE_STRING, PY_TYPE_MARKUP:
+        s, _ := args[0].AsString()
+        return VariableType{PY_TYPE_INT, int64(utf8.RuneCountInString(s))}, nil
+      case PY_TYPE_DICT:
+        d, _ := args[0].Data.(map[VariableType]VariableType)
+        return VariableType{PY_TYPE_INT, int64(len(d))}, nil
+      case PY_TYPE_LIST, PY_TYPE_TUPLE:
+        items, _ := args[0].AsList()
+        return VariableType{PY_TYPE_INT, int64(len(items))}, nil
+      case PY_TYPE_UNDEFINED:
+        if _, err := iterItems(args[0]); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        return VariableType{PY_TYPE_INT, int64(0)}, nil
+      }
+      return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("object of type '" + PyTypeToString(args[0].Type) + "' has no len()")
+    }, []CallableArg {
+      {"obj", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["count"] = filters["length"]
+  filters["join"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      c := args[0].Data.(*Context)
+      items, err := iterItems(args[1])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      values := []VariableType{args[2]}
+      for _, item := range items {
+        v, err := itemKey(c, item, args[3])
+        if err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        values = append(values, v)
+      }
+      // when any of it is markup, the rest is escaped so that the
+      // result can be markup too
+      res_type := PY_TYPE_STRING
+      for _, v := range values {
+        if v.Type == PY_TYPE_MARKUP {
+          res_type = PY_TYPE_MARKUP
+        }
+      }
+      strs := make([]string, len(values))
+      for idx, v := range values {
+        if res_type == PY_TYPE_MARKUP {
+          if v, err = Escape(v); err != nil {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, err
+          }
+        }
+        if strs[idx], err = VariableResToString(v); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+      }
+      return VariableType{res_type, strings.Join(strs[1:], strs[0])}, nil
+    }, []CallableArg {
+      {"context", VariableType{PY_TYPE_CONTEXT, nil},},
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"d", VariableType{PY_TYPE_STRING, ""},},
+      {"attribute", VariableType{PY_TYPE_NONE, nil},},
+    },
+  }
+  filters["sort"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      c := args[0].Data.(*Context)
+      items, err := iterItems(args[1])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      flags, err := argBools([]VariableType{args[2], args[3]})
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      // a comma separated attribute sorts by each of them in turn
+      attrs := []VariableType{args[4]}
+      if attr, ok := args[4].Data.(string); ok && strings.Contains(attr, ",") {
+        attrs = make([]VariableType, 0)
+        for _, part := range strings.Split(attr, ",") {
+          attrs = append(attrs, VariableType{PY_TYPE_STRING, strings.TrimSpace(part)})
+        }
+      }
+      keys := make([]VariableType, len(items))
+      for idx, item := range items {
+        key := make([]VariableType, len(attrs))
+        for attr_idx, attr := range attrs {
+          if key[attr_idx], err = itemKey(c, item, attr); err != nil {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, err
+          }
+        }
+        if len(attrs) == 1 {
+          keys[idx] = key[0]
+        } else {
+          keys[idx] = VariableType{PY_TYPE_LIST, key}
+        }
+      }
+      sorted, err := sortItems(items, keys, flags[0], flags[1])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      return VariableType{PY_TYPE_LIST, sorted}, nil
+    }, []CallableArg {
+      {"context", VariableType{PY_TYPE_CONTEXT, nil},},
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"reverse", VariableType{PY_TYPE_BOOL, false},},
+      {"case_sensitive", VariableType{PY_TYPE_BOOL, false},},
+      {"attribute", VariableType{PY_TYPE_NONE, nil},},
+    },
+  }
+  filters["dictsort"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      if args[0].Type != PY_TYPE_DICT {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("dictsort can only sort a dict, not a '" + PyTypeToString(args[0].Type) + "'")
+      }
+      flags, err := argBools([]VariableType{args[1], args[3]})
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      by, err := VariableResToString(args[2])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      pos := 0
+      if by == "value" {
+        pos = 1
+      } else if by != "key" {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("You can only sort by either 'key' or 'value'")
+      }
+      pairs := dictPairs(args[0])
+      keys := make([]VariableType, len(pairs))
+      for idx, pair := range pairs {
+        keys[idx] = pair.Data.([]VariableType)[pos]
+      }
+      sorted, err := sortItems(pairs, keys, flags[1], flags[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      return VariableType{PY_TYPE_LIST, sorted}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"case_sensitive", VariableType{PY_TYPE_BOOL, false},},
+      {"by", VariableType{PY_TYPE_STRING, "key"},},
+      {"reverse", VariableType{PY_TYPE_BOOL, false},},
+    },
+  }
+  filters["items"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      switch args[0].Type {
+      case PY_TYPE_DICT:
+        return VariableType{PY_TYPE_LIST, dictPairs(args[0])}, nil
+      case PY_TYPE_UNDEFINED:
+        if _, err := iterItems(args[0]); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        return VariableType{PY_TYPE_LIST, []VariableType{}}, nil
+      }
+      return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("Can only get item pairs from a mapping.")
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["reverse"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      items, err := iterItems(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      res := make([]VariableType, len(items))
+      for idx, item := range items {
+        res[len(items) - idx - 1] = item
+      }
+      if args[0].Type == PY_TYPE_STRING || args[0].Type == PY_TYPE_MARKUP {
+        strs, _ := argStrings(res)
+        return VariableType{args[0].Type, strings.Join(strs, "")}, nil
+      }
+      return VariableType{PY_TYPE_LIST, res}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["unique"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      c := args[0].Data.(*Context)
+      items, err := iterItems(args[1])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      case_sensitive, err := args[2].AsBool()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      res := make([]VariableType, 0)
+      seen := make([]VariableType, 0)
+      for _, item := range items {
+        key, err := itemKey(c, item, args[3])
+        if err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        duplicate := false
+        for _, s := range seen {
+          if cmp, err := compareValues(key, s, case_sensitive); IsUndefinedError(err) {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, err
+          } else if err == nil && cmp == 0 {
+            duplicate = true
+            break
+          }
+        }
+        if !duplicate {
+          seen = append(seen, key)
+          res = append(res, item)
+        }
+      }
+      return VariableType{PY_TYPE_LIST, res}, nil
+    }, []CallableArg {
+      {"context", VariableType{PY_TYPE_CONTEXT, nil},},
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"case_sensitive", VariableType{PY_TYPE_BOOL, false},},
+      {"attribute", VariableType{PY_TYPE_NONE, nil},},
+    },
+  }
+  filters["min"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return minMaxItem(args, -1)
+    }, []CallableArg {
+      {"context", VariableType{PY_TYPE_CONTEXT, nil},},
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"case_sensitive", VariableType{PY_TYPE_BOOL, false},},
+      {"attribute", VariableType{PY_TYPE_NONE, nil},},
+    },
+  }
+  filters["max"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return minMaxItem(args, 1)
+    }, []CallableArg {
+      {"context", VariableType{PY_TYPE_CONTEXT, nil},},
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"case_sensitive", VariableType{PY_TYPE_BOOL, false},},
+      {"attribute", VariableType{PY_TYPE_NONE, nil},},
+    },
+  }
+  filters["sum"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      c := args[0].Data.(*Context)
+      items, err := iterItems(args[1])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      total := args[3]
+      for _, item := range items {
+        v, err := itemKey(c, item, args[2])
+        if err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        if err := undefinedOperand(total, v); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        if total.Type == PY_TYPE_FLOAT || v.Type == PY_TYPE_FLOAT {
+          l, l_err := total.AsFloat()
+          r, r_err := v.AsFloat()
+          if l_err != nil || r_err != nil {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("unsupported operand type(s) for +: '" + PyTypeToString(total.Type) + "' and '" + PyTypeToString(v.Type) + "'")
+          }
+          total = VariableType{PY_TYPE_FLOAT, l + r}
+        } else {
+          l, l_err := total.AsInt()
+          r, r_err := v.AsInt()
+          if l_err != nil || r_err != nil {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("unsupported operand type(s) for +: '" + PyTypeToString(total.Type) + "' and '" + PyTypeToString(v.Type) + "'")
+          }
+          total = VariableType{PY_TYPE_INT, l + r}
+        }
+      }
+      return total, nil
+    }, []CallableArg {
+      {"context", VariableType{PY_TYPE_CONTEXT, nil},},
+      {"iterable", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"attribute", VariableType{PY_TYPE_NONE, nil},},
+      {"start", VariableType{PY_TYPE_INT, int64(0)},},
+    },
+  }
+  filters["batch"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      items, err := iterItems(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      linecount, err := args[1].AsInt()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      if linecount < 1 {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("the batch size must be at least 1")
+      }
+      res := make([]VariableType, 0)
+      for start := 0; start < len(items); start += int(linecount) {
+        end := start + int(linecount)
+        if end > len(items) {
+          end = len(items)
+        }
+        batch := append([]VariableType{}, items[start:end]...)
+        for args[2].Type != PY_TYPE_NONE && len(batch) < int(linecount) {
+          batch = append(batch, args[2])
+        }
+        res = append(res, VariableType{PY_TYPE_LIST, batch})
+      }
+      return VariableType{PY_TYPE_LIST, res}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"linecount", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"fill_with", VariableType{PY_TYPE_NONE, nil},},
+    },
+  }
+  filters["slice"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      items, err := iterItems(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      slices, err := args[1].AsInt()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      if slices < 1 {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("the number of slices must be at least 1")
+      }
+      return VariableType{PY_TYPE_LIST, sliceItems(items, int(slices), args[2])}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"slices", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"fill_with", VariableType{PY_TYPE_NONE, nil},},
+    },
+  }
+  filters["list"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      items, err := iterItems(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      return VariableType{PY_TYPE_LIST, append([]VariableType{}, items...)}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["random"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      items, err := iterItems(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      if len(items) == 0 {
+        return VariableType{PY_TYPE_UNDEFINED, Undefined{}}, nil
+      }
+      return items[rand.Intn(len(items))], nil
+    }, []CallableArg {
+      {"seq", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+}
+
+//-------------------------------------------------------------------------------------------------
+// The higher-order filters apply other filters and tests to the items,
+// looking them up by name in the context they are called from.
+
+// callArgs turns the *args and **kwargs of a filter back into the args
+// for a call it makes.
+func callArgs(pos_args []VariableType, named_args map[VariableType]VariableType) []CallableArg {
+  res := make([]CallableArg, 0)
+  for _, arg := range pos_args {
+    res = append(res, CallableArg{"", arg})
+  }
+  names := make([]string, 0)
+  for k, _ := range named_args {
+    if name, ok := k.Data.(string); ok {
+      names = append(names, name)
+    }
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    res = append(res, CallableArg{name, named_args[VariableType{PY_TYPE_STRING, name}]})
+  }
+  return res
+}
+
+// selectItems keeps the items which pass the test named by the first of
+// the args, or are true when no test is named. With lookup_attr, the
+// first arg is an attribute, and the test is done on it instead. When
+// reject is set, the items which pass are the ones dropped.
+func selectItems(args []VariableType, lookup_attr bool, reject bool) (VariableType, error) {
+  c := args[0].Data.(*Context)
+  items, err := iterItems(args[1])
+  if err != nil {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, err
+  }
+  pos_args, _ := args[2].Data.([]VariableType)
+  named_args, _ := args[3].Data.(map[VariableType]VariableType)
+  attribute := VariableType{PY_TYPE_NONE, nil}
+  if lookup_attr {
+    if len(pos_args) == 0 {
+      return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("Missing parameter for attribute name")
+    }
+    attribute, pos_args = pos_args[0], pos_args[1:]
+  }
+  test_name := ""
+  if len(pos_args) > 0 {
+    if test_name, err = VariableResToString(pos_args[0]); err != nil {
+      return VariableType{PY_TYPE_UNDEFINED, nil}, err
+    }
+    pos_args = pos_args[1:]
+  }
+  test_args := callArgs(pos_args, named_args)
+  res := make([]VariableType, 0)
+  for _, item := range items {
+    v, err := itemKey(c, item, attribute)
+    if err != nil {
+      return VariableType{PY_TYPE_UNDEFINED, nil}, err
+    }
+    var passed bool
+    if test_name == "" {
+      passed, err = v.AsBool()
+    } else if test_res, test_err := c.callTest(test_name, v, test_args); test_err != nil {
+      err = test_err
+    } else {
+      passed, err = test_res.AsBool()
+    }
+    if err != nil {
+      return VariableType{PY_TYPE_UNDEFINED, nil}, err
+    }
+    if passed != reject {
+      res = append(res, item)
+    }
+  }
+  return VariableType{PY_TYPE_LIST, res}, nil
+}
+
+// The args for the select filters, which take the context along with
+// the args and named args for the test.
+var select_filter_args = []CallableArg {
+  {"context", VariableType{PY_TYPE_CONTEXT, nil},},
+  {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+  {"*args", VariableType{PY_TYPE_UNDEFINED, nil},},
+  {"**kwargs", VariableType{PY_TYPE_UNDEFINED, nil},},
+}
+
+func addHigherOrderFilters(filters map[string]PyCallable) {
+  filters["map"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      c := args[0].Data.(*Context)
+      items, err := iterItems(args[1])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      pos_args, _ := args[2].Data.([]VariableType)
+      named_args, _ := args[3].Data.(map[VariableType]VariableType)
+      res := make([]VariableType, len(items))
+      if len(pos_args) == 0 {
+        // with no filter, the items are mapped to an attribute, which
+        // may have a default for when the attribute is missing
+        attribute, ok := named_args[VariableType{PY_TYPE_STRING, "attribute"}]
+        if !ok {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("map requires a filter or an attribute")
+        }
+        default_value, has_default := named_args[VariableType{PY_TYPE_STRING, "default"}]
+        for k, _ := range named_args {
+          if name := k.Data.(string); name != "attribute" && name != "default" {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("Unexpected keyword argument '" + name + "'")
+          }
+        }
+        for idx, item := range items {
+          if res[idx], err = itemKey(c, item, attribute); err != nil {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, err
+          }
+          if res[idx].Type == PY_TYPE_UNDEFINED && has_default {
+            res[idx] = default_value
+          }
+        }
+        return VariableType{PY_TYPE_LIST, res}, nil
+      }
+      filter_name, err := VariableResToString(pos_args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      filter_args := callArgs(pos_args[1:], named_args)
+      for idx, item := range items {
+        if res[idx], err = c.callFilter(filter_name, item, filter_args); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+      }
+      return VariableType{PY_TYPE_LIST, res}, nil
+    }, select_filter_args,
+  }
+  filters["select"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return selectItems(args, false, false)
+    }, select_filter_args,
+  }
+  filters["reject"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return selectItems(args, false, true)
+    }, select_filter_args,
+  }
+  filters["selectattr"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return selectItems(args, true, false)
+    }, select_filter_args,
+  }
+  filters["rejectattr"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return selectItems(args, true, true)
+    }, select_filter_args,
+  }
+  filters["groupby"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      c := args[0].Data.(*Context)
+      items, err := iterItems(args[1])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      case_sensitive, err := args[4].AsBool()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      keys := make([]VariableType, len(items))
+      for idx, item := range items {
+        if keys[idx], err = itemKey(c, item, args[2]); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        // missing keys are replaced by the default before the items
+        // are sorted, or group together as undefined without one
+        if keys[idx].Type == PY_TYPE_UNDEFINED {
+          if args[3].Type != PY_TYPE_NONE {
+            keys[idx] = args[3]
+          } else if u := AsUndefined(keys[idx]); u.Policy == UNDEFINED_STRICT {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, u.Error()
+          }
+        }
+      }
+      sorted, err := sortItems(items, keys, false, case_sensitive)
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      sorted_keys, _ := sortItems(keys, keys, false, case_sensitive)
+      // each group is a tuple of the grouper and the list of items,
+      // which can also be looked up by those names. The grouper is the
+      // key of the first item in the group.
+      res := make([]VariableType, 0)
+      for start := 0; start < len(sorted); {
+        end := start + 1
+        for end < len(sorted) {
+          if cmp, _ := compareValues(sorted_keys[start], sorted_keys[end], case_sensitive); cmp != 0 {
+            break
+          }
+          end += 1
+        }
+        group := NamedTuple{
+          []string{"grouper", "list"},
+          []VariableType{sorted_keys[start], VariableType{PY_TYPE_LIST, sorted[start:end]}},
+        }
+        res = append(res, VariableType{PY_TYPE_TUPLE, group})
+        start = end
+      }
+      return VariableType{PY_TYPE_LIST, res}, nil
+    }, []CallableArg {
+      {"context", VariableType{PY_TYPE_CONTEXT, nil},},
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"attribute", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"default", VariableType{PY_TYPE_NONE, nil},},
+      {"case_sensitive", VariableType{PY_TYPE_BOOL, false},},
+    },
+  }
+}
@@ -0,0 +1,183 @@
+package jinja2
+
+import (
+  "strings"
+  "testing"
+)
+
+func collectionVars() map[string]interface{} {
+  return map[string]interface{}{
+    "foo": []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+    "users": []interface{}{
+      map[interface{}]interface{}{"name": "b", "age": 30},
+      map[interface{}]interface{}{"name": "a", "age": 30},
+      map[interface{}]interface{}{"name": "c", "age": 20},
+    },
+    "values": []interface{}{
+      map[interface{}]interface{}{"value": 23, "real": map[interface{}]interface{}{"value": 2}},
+      map[interface{}]interface{}{"value": 1, "real": map[interface{}]interface{}{"value": 3}},
+      map[interface{}]interface{}{"value": 18, "real": map[interface{}]interface{}{"value": 2}},
+    },
+    "d": map[interface{}]interface{}{"aa": 0, "b": 1, "c": 2, "AB": 3},
+  }
+}
+
+func TestSequenceFilters(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), collectionVars(), map[string]string{
+    `{{ foo|first }}|{{ foo|last }}|{{ "abc"|first }}`: "0|9|a",
+    `[{{ []|first }}{{ []|last }}]`: "[]",
+    `{{ foo|length }}|{{ "abc"|count }}|{{ d|length }}|{{ missing|length }}`: "10|3|4|0",
+    `{{ [1, 2, 3]|join("|") }}|{{ [1, 2, 3]|join }}`: "1|2|3|123",
+    `{{ users|join(", ", attribute="name") }}`: "b, a, c",
+    `{{ "foobar"|reverse }}|{{ [1, 2, 3]|reverse }}`: "raboof|[3, 2, 1]",
+    `{{ "abc"|list }}|{{ d|list }}`: "[a, b, c]|[AB, aa, b, c]",
+    `{{ foo|batch(3)|list }}`: "[[0, 1, 2], [3, 4, 5], [6, 7, 8], [9]]",
+    `{{ foo|batch(3, "X")|list }}`: "[[0, 1, 2], [3, 4, 5], [6, 7, 8], [9, X, X]]",
+    `{{ foo|slice(3)|list }}`: "[[0, 1, 2, 3], [4, 5, 6], [7, 8, 9]]",
+    `{{ foo|slice(3, "X")|list }}`: "[[0, 1, 2, 3], [4, 5, 6, X], [7, 8, 9, X]]",
+    `{% for row in foo|batch(4) %}{{ row|join(",") }};{% endfor %}`: "0,1,2,3;4,5,6,7;8,9;",
+  })
+}
+
+func TestSortingFilters(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), collectionVars(), map[string]string{
+    `{{ [2, 3, 1]|sort }}|{{ [2, 3, 1]|sort(true) }}`: "[1, 2, 3]|[3, 2, 1]",
+    `{{ ["a", "B", "c"]|sort|join(",") }}`: "a,B,c",
+    `{{ ["a", "B", "c"]|sort(case_sensitive=true)|join(",") }}`: "B,a,c",
+    `{{ [2.5, 1, 0]|sort }}`: "[0, 1, 2.5]",
+    `{{ values|sort(attribute="value")|join(",", attribute="value") }}`: "1,18,23",
+    `{{ values|sort(attribute="real.value")|join(",", attribute="value") }}`: "23,18,1",
+    `{{ users|sort(attribute="age,name")|join(",", attribute="name") }}`: "c,a,b",
+    `{{ users|sort(attribute="age", reverse=true)|join(",", attribute="name") }}`: "b,a,c",
+    `{{ ["b", "A", "a", "b"]|unique|join }}`: "bA",
+    `{{ ["b", "A", "a", "b"]|unique(case_sensitive=true)|join }}`: "bAa",
+    `{{ users|unique(attribute="age")|join(",", attribute="name") }}`: "b,c",
+    `{{ [1, 2, 3]|min }}|{{ [1, 2, 3]|max }}`: "1|3",
+    `{{ ["a", "B"]|min }}|{{ ["a", "B"]|min(case_sensitive=true) }}`: "a|B",
+    `{% set u = users|max(attribute="age") %}{% set v = users|min(attribute="age") %}{{ u.name }}|{{ v.name }}`: "b|c",
+    `[{{ []|max }}]`: "[]",
+    `{{ d|dictsort }}`: "[[aa, 0], [AB, 3], [b, 1], [c, 2]]",
+    `{{ d|dictsort(true) }}`: "[[AB, 3], [aa, 0], [b, 1], [c, 2]]",
+    `{{ d|dictsort(false, "value") }}`: "[[aa, 0], [b, 1], [c, 2], [AB, 3]]",
+    `{{ d|dictsort(reverse=true) }}`: "[[c, 2], [b, 1], [AB, 3], [aa, 0]]",
+    `{% for k, v in d|items %}{{ k }}={{ v }};{% endfor %}`: "AB=3;aa=0;b=1;c=2;",
+    `{{ missing|items }}`: "[]",
+  })
+}
+
+func TestSumFilter(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), collectionVars(), map[string]string{
+    `{{ [1, 2, 3, 4, 5, 6]|sum }}`: "21",
+    `{{ values|sum(attribute="value") }}`: "42",
+    `{{ values|sum("real.value") }}`: "7",
+    `{{ [1, 2.5]|sum }}|{{ [1, 2]|sum(start=10) }}|{{ []|sum }}`: "3.5|13|0",
+  })
+}
+
+func TestRandomFilter(t *testing.T) {
+  vars := collectionVars()
+  for i := 0; i < 20; i++ {
+    res := renderWithEnv(t, NewEnvironment(nil), `{{ foo|random }}`, vars)
+    if len(res) != 1 || !strings.Contains("0123456789", res) {
+      t.Errorf("Template result was incorrect. Got: '%s' but expected a single digit", res)
+    }
+  }
+}
+
+func TestJoinMarkup(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.Autoescape = func(string) bool { return true }
+  vars := map[string]interface{}{"items": []interface{}{"<foo>", Markup("<span>foo</span>")}}
+  checkFilterResults(t, env, vars, map[string]string{
+    `{{ items|join }}`: "&lt;foo&gt;<span>foo</span>",
+    `{{ ["<a>", "<b>"]|join("<br>"|safe) }}`: "&lt;a&gt;<br>&lt;b&gt;",
+    `{{ ["<a>", "<b>"]|join(",") }}`: "&lt;a&gt;,&lt;b&gt;",
+  })
+}
+
+func TestCollectionFilterErrors(t *testing.T) {
+  env := NewEnvironment(nil)
+  for _, source := range []string{
+    `{{ 1|first }}`,
+    `{{ 1|length }}`,
+    `{{ [1, "a"]|sort }}`,
+    `{{ {"a": 1}|dictsort(by="x") }}`,
+    `{{ [1, 2]|items }}`,
+    `{{ [1, 2]|batch(0) }}`,
+    `{{ ["a"]|sum }}`,
+  } {
+    if err := renderUndefinedError(t, env, source); err == nil {
+      t.Errorf("expected an error rendering '%s'", source)
+    }
+  }
+  env.Undefined = UNDEFINED_STRICT
+  if err := renderUndefinedError(t, env, `{{ missing|list }}`); !IsUndefinedError(err) {
+    t.Errorf("expected an undefined error, got: %v", err)
+  }
+}
+
+func TestCollectionFilterSandbox(t *testing.T) {
+  sandbox := NewSandboxedEnvironment(nil)
+  vars := map[string]interface{}{
+    "users": []interface{}{
+      map[interface{}]interface{}{"name": "john", "age": 3, "_password": "s1"},
+      map[interface{}]interface{}{"name": "jane", "age": 2, "_password": "s2"},
+    },
+  }
+  source := `{{ users|sort(attribute="age")|join(",", attribute="name") }}|{{ users|sum(attribute="age") }}|{% set oldest = users|max(attribute="age") %}{{ oldest.name }}`
+  if res, err := renderSandboxed(t, sandbox, source, vars); err != nil || res != "jane,john|5|john" {
+    t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, "jane,john|5|john")
+  }
+  for _, source := range []string{
+    `{{ users|join(",", attribute="_password") }}`,
+    `{{ users|sort(attribute="_password") }}`,
+    `{{ users|sort(attribute="name, _password") }}`,
+    `{{ users|unique(attribute="_password")|list }}`,
+    `{{ users|min(attribute="_password") }}`,
+    `{{ users|max(attribute="_password") }}`,
+    `{{ users|sum(attribute="_password") }}`,
+  } {
+    if _, err := renderSandboxed(t, sandbox, source, vars); !IsSecurityError(err) {
+      t.Errorf("expected a security error rendering '%s', got: %v", source, err)
+    }
+  }
+}
+
+func TestCollectionFilterUndefinedAttributes(t *testing.T) {
+  env := NewEnvironment(nil)
+  checkFilterResults(t, env, collectionVars(), map[string]string{
+    `{{ users|sort(attribute="zzz")|join(",", attribute="name") }}`: "b,a,c",
+    `{{ users|sort(attribute="zzz", reverse=true)|unique(attribute="zzz")|join(",", attribute="name") }}`: "b",
+    `[{{ users|join(",", attribute="zzz") }}]|{{ users|max(attribute="zzz")|length }}`: "[,,]|2",
+  })
+  env.Undefined = UNDEFINED_DEBUG
+  checkFilterResults(t, env, collectionVars(), map[string]string{
+    `{{ users|first|list|join(",", attribute="zzz") }}`: "{{ no such element: string object['zzz'] }},{{ no such element: string object['zzz'] }}",
+  })
+  env.Undefined = UNDEFINED_STRICT
+  for _, source := range []string{
+    `{{ users|sort(attribute="zzz") }}`,
+    `{{ users|join(",", attribute="zzz") }}`,
+    `{{ users|unique(attribute="zzz")|list }}`,
+    `{{ users|min(attribute="zzz") }}`,
+    `{{ users|sum(attribute="zzz") }}`,
+  } {
+    template, err := env.FromString(source)
+    if err != nil {
+      t.Fatalf("error parsing template: %v", err)
+    }
+    if _, err := template.Render(env.NewContext(collectionVars())); !IsUndefinedError(err) {
+      t.Errorf("expected an undefined error rendering '%s', got: %v", source, err)
+    }
+  }
+  // sorting values which are there against ones which aren't fails
+  // with any policy
+  env.Undefined = UNDEFINED_LENIENT
+  template, err := env.FromString(`{{ [{"a": 1}, {}]|sort(attribute="a") }}`)
+  if err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  if _, err := template.Render(env.NewContext(collectionVars())); !IsUndefinedError(err) {
+    t.Errorf("expected an undefined error, got: %v", err)
+  }
+}
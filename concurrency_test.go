@@ -0,0 +1,85 @@
+package jinja2
+
+import (
+  "strconv"
+  "sync"
+  "testing"
+)
+
+func TestLoopDoesNotClobberOuterVariables(t *testing.T) {
+  source := "{{ item }}|{% for item in items %}{{ item }}{{ loop.index }}{% endfor %}|{{ item }}{{ loop is defined }}"
+  vars := map[string]interface{}{"item": "outer", "items": []interface{}{"a", "b"}}
+  expected := "outer|a1b2|outerfalse"
+  context := NewContext(vars)
+  template := new(Template)
+  if err := template.Parse(source); err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  if res, err := template.Render(context); err != nil || res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, expected)
+  }
+  // the context the template rendered with is unchanged
+  if len(context.Variables) != 2 {
+    t.Errorf("rendering changed the variables in the context: %v", context.Variables)
+  }
+}
+
+var concurrentTemplates = map[string]string{
+  "base.html": "<h1>{% block title %}{% endblock %}</h1>{% block body %}{% endblock %}",
+  "macros.html": "{% macro row(x) %}<td>{{ x }}{{ caller() }}</td>{% endmacro %}",
+  "footer.html": "<p>{{ id }}</p>",
+  "page.html": `{% extends "base.html" %}{% from "macros.html" import row %}` +
+    `{% block title %}{{ id }}{% endblock %}` +
+    `{% block body %}{% for item in items %}{% set total = item * id %}{% call row(total) %}{{ loop.index }}{% endcall %}{% endfor %}` +
+    `{% with id = id + 1 %}{{ id }}{% endwith %}{% include "footer.html" %}{% endblock %}`,
+}
+
+func concurrentExpected(id int) string {
+  res := "<h1>" + strconv.Itoa(id) + "</h1>"
+  for i := 1; i <= 3; i++ {
+    res += "<td>" + strconv.Itoa(i * id) + strconv.Itoa(i) + "</td>"
+  }
+  return res + strconv.Itoa(id + 1) + "<p>" + strconv.Itoa(id) + "</p>"
+}
+
+// The renders share the environment, the parsed templates and, for the
+// first half, the same context. Run with -race to check they don't
+// interfere with each other.
+func TestConcurrentRendering(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(concurrentTemplates))
+  shared := env.NewContext(map[string]interface{}{"id": 7, "items": []interface{}{1, 2, 3}})
+  var wg sync.WaitGroup
+  errs := make(chan string, 64)
+  for n := 0; n < 32; n++ {
+    wg.Add(1)
+    go func(n int) {
+      defer wg.Done()
+      template, err := env.GetTemplate("page.html")
+      if err != nil {
+        errs <- err.Error()
+        return
+      }
+      id := 7
+      context := shared
+      if n % 2 == 1 {
+        id = n
+        context = env.NewContext(map[string]interface{}{"id": id, "items": []interface{}{1, 2, 3}})
+      }
+      for i := 0; i < 10; i++ {
+        res, err := template.Render(context)
+        if err != nil {
+          errs <- err.Error()
+          return
+        } else if res != concurrentExpected(id) {
+          errs <- "Got: '" + res + "' but expected '" + concurrentExpected(id) + "'"
+          return
+        }
+      }
+    }(n)
+  }
+  wg.Wait()
+  close(errs)
+  for err := range errs {
+    t.Errorf("error rendering concurrently: %s", err)
+  }
+}
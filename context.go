@@ -2,37 +2,224 @@ package jinja2
 
 import (
   "errors"
+  "io"
   "reflect"
-  "strconv"
+  "strings"
+  "sync"
 )
 
+// A Context holds the variables, filters, tests and calls a template
+// renders with. Rendering never changes the context it is given: each
+// render, loop iteration, macro call and scoped tag gets its own frame,
+// a derived context layered over the one around it, and only the
+// frames are written to. A context and a parsed template can be shared
+// by renders on several goroutines, as long as nothing is added to the
+// context while they are running.
+//
+// Filters and Tests start out empty, and only hold what is added to the
+// context itself. A filter or test is looked up in the context and the
+// contexts around it first, then in the environment when there is one,
+// and otherwise in the default filters and tests, so adding one here
+// overrides the built-in of the same name.
 type Context struct {
   Variables map[string]VariableType
   Filters map[string]PyCallable
   Tests map[string]PyCallable
   PyCalls map[string]PyCallable
+  Env *Environment
+  parent *Context
+  state *renderState
+  template *Template
+  autoescape bool
+  budget *renderBudget
 }
 
 func (self *Context) LoadDefaultFilters() {
-  self.Filters["int"] = PyCallable {
+  AddDefaultFilters(self.Filters)
+}
+
+func (self *Context) LoadDefaultTests() {
+  AddDefaultTests(self.Tests)
+}
+
+// The default filters and tests are what contexts which aren't from an
+// environment fall back to. They are made the first time one is looked
+// up, and shared by every such context.
+var defaults_once sync.Once
+var default_filters map[string]PyCallable
+var default_tests map[string]PyCallable
+
+func loadDefaults() {
+  defaults_once.Do(func() {
+    default_filters = make(map[string]PyCallable)
+    default_tests = make(map[string]PyCallable)
+    AddDefaultFilters(default_filters)
+    AddDefaultTests(default_tests)
+  })
+}
+
+// derive creates a new, empty context layered on top of this one.
+// Lookups which miss in the child fall through to the parent, while
+// anything set on the child is never visible to the parent.
+func (self *Context) derive() *Context {
+  child := new(Context)
+  child.Variables = make(map[string]VariableType)
+  child.Filters = make(map[string]PyCallable)
+  child.Tests = make(map[string]PyCallable)
+  child.PyCalls = make(map[string]PyCallable)
+  child.Env = self.Env
+  child.parent = self
+  child.state = self.state
+  child.template = self.template
+  child.autoescape = self.autoescape
+  child.budget = self.budget
+  return child
+}
+
+// isolated creates a new context from the environment, for templates
+// which are included or imported without the current context. It is
+// still part of the same render, so it shares the render's budget.
+func (self *Context) isolated() *Context {
+  c := self.state.env.NewContext(nil)
+  c.budget = self.budget
+  return c
+}
+
+// captureWriter wraps a buffer used to capture output so that the
+// output limit also applies to it.
+func (self *Context) captureWriter(res *strings.Builder) io.Writer {
+  return self.budget.writer(res)
+}
+
+// templateName is the name of the template whose code is running.
+func (self *Context) templateName() string {
+  if self.template == nil {
+    return ""
+  }
+  return self.template.Name
+}
+
+// The lookup methods check the context and its parents first and then
+// fall back to the environment the context was created from, if any.
+// Filters and tests are otherwise looked up in the defaults.
+func (self *Context) LookupVariable(name string) (VariableType, bool) {
+  for cur := self; cur != nil; cur = cur.parent {
+    if v, ok := cur.Variables[name]; ok {
+      return v, true
+    }
+  }
+  if self.Env != nil {
+    if v, ok := self.Env.Globals[name]; ok {
+      return v, true
+    }
+  }
+  return VariableType{PY_TYPE_UNDEFINED, nil}, false
+}
+func (self *Context) LookupFilter(name string) (PyCallable, bool) {
+  for cur := self; cur != nil; cur = cur.parent {
+    if f, ok := cur.Filters[name]; ok {
+      return f, true
+    }
+  }
+  if self.Env != nil {
+    if f, ok := self.Env.Filters[name]; ok {
+      return f, true
+    }
+  } else {
+    loadDefaults()
+    if f, ok := default_filters[name]; ok {
+      return f, true
+    }
+  }
+  return PyCallable{}, false
+}
+func (self *Context) LookupTest(name string) (PyCallable, bool) {
+  for cur := self; cur != nil; cur = cur.parent {
+    if t, ok := cur.Tests[name]; ok {
+      return t, true
+    }
+  }
+  if self.Env != nil {
+    if t, ok := self.Env.Tests[name]; ok {
+      return t, true
+    }
+  } else {
+    loadDefaults()
+    if t, ok := default_tests[name]; ok {
+      return t, true
+    }
+  }
+  return PyCallable{}, false
+}
+func (self *Context) LookupPyCall(name string) (PyCallable, bool) {
+  for cur := self; cur != nil; cur = cur.parent {
+    if call, ok := cur.PyCalls[name]; ok {
+      return call, true
+    }
+  }
+  return PyCallable{}, false
+}
+
+func AddDefaultFilters(filters map[string]PyCallable) {
+  addStringFilters(filters)
+  addCollectionFilters(filters)
+  addHigherOrderFilters(filters)
+  addNumericFilters(filters)
+  filters["safe"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      s, err := VariableResToString(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      return VariableType{PY_TYPE_MARKUP, s}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["escape"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return Escape(args[0])
+    }, []CallableArg {
+      {"s", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["e"] = filters["escape"]
+  filters["forceescape"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      s, err := VariableResToString(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      return VariableType{PY_TYPE_MARKUP, EscapeString(s)}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["default"] = PyCallable {
     func(args []VariableType) (VariableType, error) {
       val := args[0]
-      switch val.Type {
-      case PY_TYPE_INT:
-        return val, nil
-      case PY_TYPE_STRING:
-        new_v, err := strconv.ParseInt(val.Data.(string), 10, 10)
-        if err != nil {
+      if val.Type == PY_TYPE_UNDEFINED {
+        return args[1], nil
+      }
+      if use_bool, err := args[2].AsBool(); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      } else if use_bool {
+        // with boolean set, any false value is replaced too
+        if b_val, err := val.AsBool(); err != nil {
           return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        } else if !b_val {
+          return args[1], nil
         }
-        return VariableType{PY_TYPE_INT, new_v}, nil
       }
       return val, nil
     }, []CallableArg {
-      {"val", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"default_value", VariableType{PY_TYPE_STRING, ""},},
+      {"boolean", VariableType{PY_TYPE_BOOL, false},},
     },
   }
-  self.Filters["bool"] = PyCallable {
+  filters["d"] = filters["default"]
+  filters["bool"] = PyCallable {
     func(args []VariableType) (VariableType, error) {
       val := args[0]
       b_val, err := val.AsBool()
@@ -47,8 +234,9 @@ func (self *Context) LoadDefaultFilters() {
   }
 }
 
-func (self *Context) LoadDefaultTests() {
-  self.Tests["defined"] = PyCallable{
+func AddDefaultTests(tests map[string]PyCallable) {
+  addBuiltinTests(tests)
+  tests["defined"] = PyCallable{
     func(args []VariableType) (VariableType, error) {
       // FIXME: the jinja2 builtin accepts a value as an arg,
       //        which is returned if the test evaluates to true
@@ -62,6 +250,13 @@ func (self *Context) LoadDefaultTests() {
       {"val", VariableType{PY_TYPE_UNDEFINED, nil},},
     },
   }
+  tests["undefined"] = PyCallable{
+    func(args []VariableType) (VariableType, error) {
+      return VariableType{PY_TYPE_BOOL, args[0].Type == PY_TYPE_UNDEFINED}, nil
+    }, []CallableArg {
+      {"val", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
 }
 
 func (self *Context) AddVariables(vars map[string]interface{}) error {
@@ -75,6 +270,11 @@ func (self *Context) AddVariables(vars map[string]interface{}) error {
   return nil
 }
 
+// NewContext creates a context which isn't from an environment. Its
+// filters and tests are empty, so lookups fall back to the environment
+// of the template it renders, or to the default filters and tests when
+// the template has no environment. Filters and tests added to the
+// context take precedence over both.
 func NewContext(vars map[string]interface{}) *Context {
   c := new(Context)
   c.Variables = make(map[string]VariableType)
@@ -87,12 +287,13 @@ func NewContext(vars map[string]interface{}) *Context {
   c.Filters = make(map[string]PyCallable)
   c.Tests = make(map[string]PyCallable)
   c.PyCalls = make(map[string]PyCallable)
-  c.LoadDefaultFilters()
-  c.LoadDefaultTests()
   return c
 }
 
 func InterfaceToPyType(v interface{}) PyType {
+  if _, ok := v.(Markup); ok {
+    return PY_TYPE_MARKUP
+  }
   r := reflect.ValueOf(v)
   switch r.Kind() {
   case reflect.String:
@@ -120,6 +321,8 @@ func GoVarToPyVar(v interface{}) (VariableType, error) {
   case PY_TYPE_STRING:
     v = v.(string)
     return VariableType{PY_TYPE_STRING, v}, nil
+  case PY_TYPE_MARKUP:
+    return VariableType{PY_TYPE_MARKUP, string(v.(Markup))}, nil
   case PY_TYPE_INT:
     v = int64(v.(int))
     return VariableType{PY_TYPE_INT, v}, nil
@@ -45,6 +45,25 @@ func TestForLoopMultipleValues(t *testing.T) {
   }
 }
 
+func TestForLoopVariables(t *testing.T) {
+  context := NewContext(map[string]interface{} {
+      "seq": []interface{}{"a", "b", "c"},
+    },
+  )
+  template := new(Template)
+  err := template.Parse("{% for item in seq %}{{ loop.index }}{{ loop.index0 }}{{ loop.revindex }}{{ loop.revindex0 }}" +
+    "{{ loop.first }}{{ loop.last }}{{ loop.length + loop.depth + loop.depth0 }};{% endfor %}")
+  if err != nil {
+    t.Errorf("error parsing template:", err)
+  }
+  expected := "1032truefalse4;2121falsefalse4;3210falsetrue4;"
+  if res, err := template.Render(context); err != nil {
+    t.Errorf("error rendering template:", err)
+  } else if res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
 func TestForLoopElse(t *testing.T) {
   context := NewContext(map[string]interface{} {
       "seq": make([]interface{}, 0),
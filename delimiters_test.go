@@ -0,0 +1,66 @@
+package jinja2
+
+import (
+  "testing"
+)
+
+func TestCustomDelimiters(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.BlockStartString = `\BLOCK{`
+  env.BlockEndString = "}"
+  env.VariableStartString = `\VAR{`
+  env.VariableEndString = "}"
+  env.CommentStartString = `\#{`
+  env.CommentEndString = "}"
+  source := `\section{\VAR{ title }}\#{ a comment }\BLOCK{ for i in seq }{\VAR{ i }}\BLOCK{ endfor }`
+  expected := `\section{Intro}{1}{2}`
+  vars := map[string]interface{}{"title": "Intro", "seq": []interface{}{1, 2}}
+  if res := renderWithEnv(t, env, source, vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestCustomDelimitersLeaveDefaultsAlone(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.BlockStartString = "[%"
+  env.BlockEndString = "%]"
+  env.VariableStartString = "[["
+  env.VariableEndString = "]]"
+  source := "{{ .Values.name }} [[ name ]] [%- if true %] ok [%- endif %]"
+  expected := "{{ .Values.name }} helm ok"
+  if res := renderWithEnv(t, env, source, map[string]interface{}{"name": "helm"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestTokenizerQuotesAndBrackets(t *testing.T) {
+  // quotes in the text around tags are plain text, while quotes and
+  // brackets inside a tag can hold the end delimiter
+  source := `It's {{ name }}'s "{{ '}}' }}" {# don't #}{% raw %}{{ x '{% endraw %}`
+  expected := `It's bob's "}}" {{ x '`
+  if res := renderString(t, source, map[string]interface{}{"name": "bob"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestLineStatements(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.LineStatementPrefix = "#"
+  env.LineCommentPrefix = "##"
+  source := "servers:\n  # for s in servers:\n  - {{ s }}  ## the host name\n  # endfor\n## trailing comment\n# if debug\ndebug: true\n# endif\ndone"
+  expected := "servers:\n  - a\n  - b\n\ndone"
+  vars := map[string]interface{}{"servers": []interface{}{"a", "b"}, "debug": false}
+  if res := renderWithEnv(t, env, source, vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestLineStatementOnlyAtLineStart(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.LineStatementPrefix = "%"
+  source := "100% done\n% for i in [1,\n    2]\n{{ i }}\n% endfor"
+  expected := "100% done\n1\n2\n"
+  if res := renderWithEnv(t, env, source, nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
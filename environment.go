@@ -0,0 +1,194 @@
+package jinja2
+
+import (
+  "errors"
+  "sync"
+)
+
+//-------------------------------------------------------------------------------------------------
+// The Environment holds the configuration shared by a set of
+// templates: the loader used to find them by name, and the filters,
+// tests and global variables available to every template rendered
+// from it. Templates fetched with GetTemplate are parsed once and
+// cached by name.
+//
+// Autoescape decides whether the output of a template is HTML escaped,
+// given the name of the template (which is empty for templates created
+// from strings). SelectAutoescape builds one based on the file
+// extension. When it is nil, nothing is escaped.
+//
+// TrimBlocks, LstripBlocks and KeepTrailingNewline control the
+// whitespace around tags in the same way as the Jinja2 options of the
+// same name, and are used for templates parsed after they are set.
+// The delimiter strings replace the default `{% %}`, `{{ }}` and
+// `{# #}` tags when they are not empty, and LineStatementPrefix and
+// LineCommentPrefix turn on line statements and line comments.
+//
+// Undefined is the policy for variables and attributes which are
+// missing, and defaults to rendering them as empty strings.
+//
+// When BytecodeCache is set, templates loaded by name are stored in it
+// once parsed, and are loaded from it rather than parsed again as long
+// as their source and the lexer settings are unchanged. Limits bound
+// the work done by each render of the environment's templates.
+type Environment struct {
+  Loader Loader
+  Autoescape func(template_name string) bool
+  TrimBlocks bool
+  LstripBlocks bool
+  KeepTrailingNewline bool
+  BlockStartString string
+  BlockEndString string
+  VariableStartString string
+  VariableEndString string
+  CommentStartString string
+  CommentEndString string
+  LineStatementPrefix string
+  LineCommentPrefix string
+  Undefined UndefinedPolicy
+  BytecodeCache BytecodeCache
+  Limits Limits
+  Filters map[string]PyCallable
+  Tests map[string]PyCallable
+  Globals map[string]VariableType
+  templates map[string]*Template
+  templates_lock sync.Mutex
+  sandbox *SandboxedEnvironment
+}
+
+func NewEnvironment(loader Loader) *Environment {
+  env := new(Environment)
+  env.Loader = loader
+  env.Filters = make(map[string]PyCallable)
+  env.Tests = make(map[string]PyCallable)
+  env.Globals = make(map[string]VariableType)
+  env.templates = make(map[string]*Template)
+  AddDefaultFilters(env.Filters)
+  AddDefaultTests(env.Tests)
+  return env
+}
+
+func (self *Environment) AddGlobals(vars map[string]interface{}) error {
+  for k, v := range vars {
+    py_v, err := GoVarToPyVar(v)
+    if err != nil {
+      return err
+    }
+    self.Globals[k] = py_v
+  }
+  return nil
+}
+
+// NewContext creates a context which uses the filters, tests and
+// globals from the environment. Anything added directly to the
+// context takes precedence over the environment.
+func (self *Environment) NewContext(vars map[string]interface{}) *Context {
+  c := new(Context)
+  c.Env = self
+  c.Variables = make(map[string]VariableType)
+  c.Filters = make(map[string]PyCallable)
+  c.Tests = make(map[string]PyCallable)
+  c.PyCalls = make(map[string]PyCallable)
+  if vars != nil {
+    err := c.AddVariables(vars)
+    if err != nil {
+      panic(err)
+    }
+  }
+  return c
+}
+
+// GetTemplate loads a template by name through the environment's
+// loader, parsing it the first time it is requested.
+func (self *Environment) GetTemplate(name string) (*Template, error) {
+  self.templates_lock.Lock()
+  t, ok := self.templates[name]
+  self.templates_lock.Unlock()
+  if ok {
+    return t, nil
+  }
+  if self.Loader == nil {
+    return nil, errors.New("no loader for this environment specified")
+  }
+  source, filename, err := self.Loader.GetSource(name)
+  if err != nil {
+    return nil, err
+  }
+  t = new(Template)
+  t.Name = name
+  t.Filename = filename
+  t.env = self
+  t.autoescape = self.shouldAutoescape(name)
+  if err := self.parseTemplate(t, source); err != nil {
+    return nil, err
+  }
+  // the template may have been loaded by another goroutine while this
+  // one was parsing it, in which case that one is kept
+  self.templates_lock.Lock()
+  defer self.templates_lock.Unlock()
+  if existing, ok := self.templates[name]; ok {
+    return existing, nil
+  }
+  self.templates[name] = t
+  return t, nil
+}
+
+// FromString parses a template from source which is not known to the
+// loader. The resulting template is not cached.
+func (self *Environment) FromString(source string) (*Template, error) {
+  t := new(Template)
+  t.env = self
+  t.autoescape = self.shouldAutoescape("")
+  if err := t.Parse(source); err != nil {
+    return nil, err
+  }
+  return t, nil
+}
+
+// parseTemplate parses the source of a template loaded by name, going
+// through the bytecode cache when there is one.
+func (self *Environment) parseTemplate(t *Template, source string) error {
+  if self.BytecodeCache == nil {
+    return t.Parse(source)
+  }
+  key := bytecodeCacheKey(t.Name, t.Filename)
+  checksum, err := sourceChecksum(source, self.lexerConfig())
+  if err != nil {
+    return t.Parse(source)
+  }
+  if data, err := self.BytecodeCache.Load(key); err == nil && data != nil {
+    if t.loadBytecode(source, checksum, data) == nil {
+      return nil
+    }
+  }
+  if err := t.Parse(source); err != nil {
+    return err
+  }
+  if data, err := t.dumpBytecode(checksum); err == nil {
+    self.BytecodeCache.Dump(key, data)
+  }
+  return nil
+}
+
+func (self *Environment) shouldAutoescape(name string) bool {
+  if self.Autoescape == nil {
+    return false
+  }
+  return self.Autoescape(name)
+}
+
+func (self *Environment) lexerConfig() LexerConfig {
+  return LexerConfig{
+    TrimBlocks: self.TrimBlocks,
+    LstripBlocks: self.LstripBlocks,
+    KeepTrailingNewline: self.KeepTrailingNewline,
+    BlockStartString: self.BlockStartString,
+    BlockEndString: self.BlockEndString,
+    VariableStartString: self.VariableStartString,
+    VariableEndString: self.VariableEndString,
+    CommentStartString: self.CommentStartString,
+    CommentEndString: self.CommentEndString,
+    LineStatementPrefix: self.LineStatementPrefix,
+    LineCommentPrefix: self.LineCommentPrefix,
+  }
+}
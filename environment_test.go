@@ -0,0 +1,184 @@
+package jinja2
+
+import (
+  "os"
+  "path/filepath"
+  "testing"
+  "testing/fstest"
+)
+
+func renderFromEnv(t *testing.T, env *Environment, name string, vars map[string]interface{}) string {
+  template, err := env.GetTemplate(name)
+  if err != nil {
+    t.Fatalf("error loading template '%s': %v", name, err)
+  }
+  res, err := template.Render(env.NewContext(vars))
+  if err != nil {
+    t.Fatalf("error rendering template '%s': %v", name, err)
+  }
+  return res
+}
+
+func TestDictLoader(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "index.html": "{{ name }}",
+  }))
+  expected := "world"
+  if res := renderFromEnv(t, env, "index.html", map[string]interface{}{"name": "world"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  if _, err := env.GetTemplate("missing.html"); !IsTemplateNotFound(err) {
+    t.Errorf("expected a template not found error, got: %v", err)
+  }
+}
+
+func TestFileSystemLoader(t *testing.T) {
+  first := t.TempDir()
+  second := t.TempDir()
+  if err := os.MkdirAll(filepath.Join(second, "sub"), 0755); err != nil {
+    t.Fatal(err)
+  }
+  os.WriteFile(filepath.Join(first, "a.txt"), []byte("first a"), 0644)
+  os.WriteFile(filepath.Join(second, "a.txt"), []byte("second a"), 0644)
+  os.WriteFile(filepath.Join(second, "sub", "b.txt"), []byte("second b"), 0644)
+
+  env := NewEnvironment(NewFileSystemLoader(first, second))
+  expected := "first a"
+  if res := renderFromEnv(t, env, "a.txt", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  expected = "second b"
+  if res := renderFromEnv(t, env, "sub/b.txt", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  if _, err := env.GetTemplate("../a.txt"); !IsTemplateNotFound(err) {
+    t.Errorf("expected a template not found error for a path outside the search path, got: %v", err)
+  }
+}
+
+func TestFSLoader(t *testing.T) {
+  env := NewEnvironment(NewFSLoader(fstest.MapFS{
+    "templates/page.html": &fstest.MapFile{Data: []byte("{{ 1 + 2 }}")},
+  }))
+  expected := "3"
+  if res := renderFromEnv(t, env, "templates/page.html", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  if _, err := env.GetTemplate("templates/missing.html"); !IsTemplateNotFound(err) {
+    t.Errorf("expected a template not found error, got: %v", err)
+  }
+}
+
+func TestPrefixAndChoiceLoaders(t *testing.T) {
+  env := NewEnvironment(NewChoiceLoader(
+    NewPrefixLoader(map[string]Loader{
+      "app": NewDictLoader(map[string]string{"index.html": "app index"}),
+    }),
+    NewDictLoader(map[string]string{
+      "index.html": "fallback index",
+      "app/other.html": "fallback other",
+    }),
+  ))
+  for name, expected := range map[string]string{
+    "app/index.html": "app index",
+    "app/other.html": "fallback other",
+    "index.html": "fallback index",
+  } {
+    if res := renderFromEnv(t, env, name, nil); res != expected {
+      t.Errorf("Template result for '%s' was incorrect. Got: '%s' but expected '%s'", name, res, expected)
+    }
+  }
+}
+
+func TestEnvironmentGlobalsAndFilters(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "index.html": "{{ site }} {{ '7'|int + 1 }} {{ site is defined }}",
+  }))
+  env.AddGlobals(map[string]interface{}{"site": "example"})
+  expected := "example 8 true"
+  if res := renderFromEnv(t, env, "index.html", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  // variables on the context shadow the environment globals
+  expected = "local 8 true"
+  if res := renderFromEnv(t, env, "index.html", map[string]interface{}{"site": "local"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestEnvironmentFilterOverrides(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "index.html": "{{ 'a'|upper }} {{ 2 is odd }}",
+  }))
+  env.Filters["upper"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return VariableType{PY_TYPE_STRING, "overridden"}, nil
+    }, []CallableArg {
+      {"s", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  env.Tests["odd"] = env.Tests["even"]
+  if c := env.NewContext(nil); len(c.Filters) != 0 || len(c.Tests) != 0 {
+    t.Errorf("expected the context to use the environment's filters and tests, not copies of them")
+  }
+  template, err := env.GetTemplate("index.html")
+  if err != nil {
+    t.Fatalf("error loading template: %v", err)
+  }
+  // the environment's filters and tests are used with a context which
+  // isn't from the environment, as well as one which is
+  expected := "overridden true"
+  for _, c := range []*Context{env.NewContext(nil), NewContext(nil)} {
+    if res, err := template.Render(c); err != nil || res != expected {
+      t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, expected)
+    }
+  }
+  // and the defaults without an environment
+  standalone := new(Template)
+  if err := standalone.Parse("{{ 'a'|upper }} {{ 2 is odd }}"); err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  if res, err := standalone.Render(NewContext(nil)); err != nil || res != "A false" {
+    t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, "A false")
+  }
+}
+
+func TestContextFilterOverrides(t *testing.T) {
+  shout := PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return VariableType{PY_TYPE_STRING, "shout"}, nil
+    }, []CallableArg {
+      {"s", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "index.html": "{{ 'a'|upper }} {{ 2 is odd }}",
+  }))
+  env.Filters["upper"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      return VariableType{PY_TYPE_STRING, "overridden"}, nil
+    }, []CallableArg {
+      {"s", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  template, err := env.GetTemplate("index.html")
+  if err != nil {
+    t.Fatalf("error loading template: %v", err)
+  }
+  standalone := new(Template)
+  if err := standalone.Parse("{{ 'a'|upper }} {{ 2 is odd }}"); err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  // the context's own filters and tests win over the environment's and
+  // over the defaults
+  expected := "shout true"
+  for _, tmpl := range []*Template{template, standalone} {
+    for _, c := range []*Context{env.NewContext(nil), NewContext(nil)} {
+      c.Filters["upper"] = shout
+      c.Tests["odd"] = env.Tests["even"]
+      if res, err := tmpl.Render(c); err != nil || res != expected {
+        t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, expected)
+      }
+    }
+  }
+}
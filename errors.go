@@ -0,0 +1,162 @@
+package jinja2
+
+import (
+  "errors"
+  "strconv"
+  "strings"
+)
+
+// TemplateSyntaxError is returned when a template can't be parsed. The
+// line and column are 1-based and relative to the whole template.
+type TemplateSyntaxError struct {
+  Message string
+  Name string
+  Filename string
+  Line int
+  Column int
+  Source string
+}
+
+func (self *TemplateSyntaxError) Error() string {
+  res := templateName(self.Name) + ":" + strconv.Itoa(self.Line) + ":" + strconv.Itoa(self.Column) + ": " + self.Message
+  if excerpt := self.Excerpt(); excerpt != "" {
+    res += "\n" + excerpt
+  }
+  return res
+}
+
+// Excerpt returns the line of the template the error is on, with a
+// caret under the column. It is empty when the source isn't known.
+func (self *TemplateSyntaxError) Excerpt() string {
+  if self.Source == "" || self.Line < 1 {
+    return ""
+  }
+  lines := strings.Split(self.Source, "\n")
+  if self.Line > len(lines) {
+    return ""
+  }
+  line := strings.TrimRight(lines[self.Line-1], "\r")
+  // keep any tabs so the caret lines up with the source
+  caret := ""
+  for i := 0; i < self.Column-1 && i < len(line); i++ {
+    if line[i] == '\t' {
+      caret += "\t"
+    } else {
+      caret += " "
+    }
+  }
+  return "  " + line + "\n  " + caret + "^"
+}
+
+// TemplateRuntimeError is returned when rendering a template fails. It
+// points at the statement of the tag that failed, and the stack holds
+// the macros, includes and blocks the error passed through on the way
+// out, innermost first.
+type TemplateRuntimeError struct {
+  Err error
+  Name string
+  Line int
+  Column int
+  Source string
+  Stack []StackFrame
+  // set when the error leaves a macro, include or block, so the tag
+  // which called it adds a frame to the stack
+  pending string
+}
+
+// A StackFrame is the place a macro, include or block was called from.
+type StackFrame struct {
+  Description string
+  Name string
+  Line int
+  Column int
+}
+
+func (self *TemplateRuntimeError) Error() string {
+  res := templateName(self.Name) + ":" + strconv.Itoa(self.Line) + ":" + strconv.Itoa(self.Column) + ": " + self.Err.Error()
+  if self.Source != "" {
+    res += "\n  in expression: " + self.Source
+  }
+  for _, frame := range self.Stack {
+    res += "\n  in " + frame.Description + " at " + templateName(frame.Name) + ":" + strconv.Itoa(frame.Line) + ":" + strconv.Itoa(frame.Column)
+  }
+  return res
+}
+
+func (self *TemplateRuntimeError) Unwrap() error {
+  return self.Err
+}
+
+func templateName(name string) string {
+  if name == "" {
+    return "<template>"
+  }
+  return name
+}
+
+// SourceSpan is where the statement of a tag is in the template, and
+// what it was.
+type SourceSpan struct {
+  Line, Column int
+  Source string
+}
+func (self SourceSpan) Span() SourceSpan {
+  return self
+}
+
+func tokenSpan(token Token, statement string) SourceSpan {
+  base := token.Base()
+  line := base.Line
+  column := base.Column + base.ContentPos - base.Pos
+  trimmed := strings.TrimLeft(statement, " \t\r\n")
+  leading := statement[:len(statement)-len(trimmed)]
+  if newlines := strings.Count(leading, "\n"); newlines > 0 {
+    line += newlines
+    column = len(leading) - strings.LastIndex(leading, "\n")
+  } else {
+    column += len(leading)
+  }
+  return SourceSpan{line, column, strings.TrimSpace(statement)}
+}
+
+// chunkError attaches the location of a chunk to an error from
+// rendering it. Errors which already have a location keep it, but
+// get a frame added when they come out of a macro, include or block.
+func chunkError(chunk Renderable, err error, c *Context) error {
+  span := SourceSpan{}
+  if spanned, ok := chunk.(interface{ Span() SourceSpan }); ok {
+    span = spanned.Span()
+  }
+  return spanError(span, err, c)
+}
+func spanError(span SourceSpan, err error, c *Context) error {
+  var syntax_err *TemplateSyntaxError
+  if errors.As(err, &syntax_err) {
+    return err
+  }
+  var runtime_err *TemplateRuntimeError
+  if errors.As(err, &runtime_err) {
+    if runtime_err.pending != "" {
+      frame := StackFrame{runtime_err.pending, c.templateName(), span.Line, span.Column}
+      runtime_err.Stack = append(runtime_err.Stack, frame)
+      runtime_err.pending = ""
+    }
+    return err
+  }
+  if span.Line == 0 {
+    // chunks without a statement, like text, have no position to give,
+    // and their only errors are from writing the output
+    return err
+  }
+  return &TemplateRuntimeError{Err: err, Name: c.templateName(), Line: span.Line, Column: span.Column, Source: span.Source}
+}
+
+// leavingFrame marks an error as coming out of a macro, include or
+// block, so that the chunk it returns to adds a frame for it.
+func leavingFrame(err error, description string) error {
+  var runtime_err *TemplateRuntimeError
+  if errors.As(err, &runtime_err) {
+    runtime_err.pending = description
+  }
+  return err
+}
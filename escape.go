@@ -0,0 +1,77 @@
+package jinja2
+
+import (
+  "path"
+  "strings"
+)
+
+var html_escaper = strings.NewReplacer(
+  "&", "&amp;",
+  "<", "&lt;",
+  ">", "&gt;",
+  `"`, "&#34;",
+  "'", "&#39;",
+)
+
+// EscapeString replaces the characters which have a special meaning
+// in HTML with their entities, the same way markupsafe does.
+func EscapeString(s string) string {
+  return html_escaper.Replace(s)
+}
+
+// Escape converts a value to markup, escaping it unless it already is
+// markup.
+func Escape(v VariableType) (VariableType, error) {
+  if v.Type == PY_TYPE_MARKUP {
+    return v, nil
+  }
+  s, err := VariableResToString(v)
+  if err != nil {
+    return VariableType{PY_TYPE_UNDEFINED, nil}, err
+  }
+  return VariableType{PY_TYPE_MARKUP, EscapeString(s)}, nil
+}
+
+// StringResultType is the type a string operation on the value should
+// return, so that operations on markup keep it marked as safe.
+func StringResultType(v VariableType) PyType {
+  if v.Type == PY_TYPE_MARKUP {
+    return PY_TYPE_MARKUP
+  }
+  return PY_TYPE_STRING
+}
+
+// SelectAutoescape builds a function for Environment.Autoescape which
+// enables autoescaping based on the extension of the template name.
+// Templates created from strings have no name, and use
+// default_for_string instead. The extensions are given without the
+// leading dot and are matched case-insensitively.
+func SelectAutoescape(enabled_extensions []string, disabled_extensions []string, default_for_string bool, default_value bool) func(string) bool {
+  return func(template_name string) bool {
+    if template_name == "" {
+      return default_for_string
+    }
+    ext := strings.ToLower(strings.TrimPrefix(path.Ext(template_name), "."))
+    for _, e := range enabled_extensions {
+      if ext == strings.ToLower(e) {
+        return true
+      }
+    }
+    for _, e := range disabled_extensions {
+      if ext == strings.ToLower(e) {
+        return false
+      }
+    }
+    return default_value
+  }
+}
+
+// rendered wraps the rendered output of a template section so it can
+// be used as a value, marking it as safe when autoescaping is enabled
+// since everything in it has already been escaped.
+func (self *Context) rendered(s string) VariableType {
+  if self.autoescape {
+    return VariableType{PY_TYPE_MARKUP, s}
+  }
+  return VariableType{PY_TYPE_STRING, s}
+}
@@ -0,0 +1,39 @@
+package jinja2
+
+import (
+  "testing"
+)
+
+func TestFilterBlock(t *testing.T) {
+  source := `{% filter upper | replace("A", "b") %}a {{ name }}{% endfilter %}`
+  expected := "b bLICE"
+  if res := renderString(t, source, map[string]interface{}{"name": "alice"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestFilterBlockNested(t *testing.T) {
+  source := `{% filter replace("x", "y") %}x{% filter upper %}x{% for i in seq %}{{ i }}{% endfor %}{% endfilter %}x{% endfilter %}`
+  expected := "yX12y"
+  if res := renderString(t, source, map[string]interface{}{"seq": []interface{}{1, 2}}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestFilterArgs(t *testing.T) {
+  source := `{{ name|replace("a", "o", 1) }} {{ name|replace(old="a", new="") }}`
+  expected := "bonana bnn"
+  if res := renderString(t, source, map[string]interface{}{"name": "banana"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestFilterBlockMissingFilter(t *testing.T) {
+  template := new(Template)
+  if err := template.Parse(`{% filter nope %}text{% endfilter %}`); err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  if _, err := template.Render(NewContext(nil)); err == nil {
+    t.Errorf("expected an error for an unknown filter")
+  }
+}
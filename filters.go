@@ -0,0 +1,746 @@
+package jinja2
+
+import (
+  "errors"
+  "fmt"
+  "html"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+  "unicode"
+  "unicode/utf8"
+)
+
+//-------------------------------------------------------------------------------------------------
+// The string filters take the same arguments as the Jinja2 filters of
+// the same name. Filters which only change the text they are given
+// keep markup as markup.
+
+// stringFilter makes a filter from a function which changes a string.
+func stringFilter(f func(string) string) PyCallable {
+  return PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      s, err := VariableResToString(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      return VariableType{StringResultType(args[0]), f(s)}, nil
+    }, []CallableArg {
+      {"s", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+}
+
+// argStrings converts the args to strings, failing on the first one
+// which can't be converted.
+func argStrings(args []VariableType) ([]string, error) {
+  res := make([]string, len(args))
+  for idx, arg := range args {
+    s, err := VariableResToString(arg)
+    if err != nil {
+      return nil, err
+    }
+    res[idx] = s
+  }
+  return res, nil
+}
+
+// argBools converts the args to their truth values.
+func argBools(args []VariableType) ([]bool, error) {
+  res := make([]bool, len(args))
+  for idx, arg := range args {
+    b, err := arg.AsBool()
+    if err != nil {
+      return nil, err
+    }
+    res[idx] = b
+  }
+  return res, nil
+}
+
+func addStringFilters(filters map[string]PyCallable) {
+  filters["upper"] = stringFilter(strings.ToUpper)
+  filters["lower"] = stringFilter(strings.ToLower)
+  filters["title"] = stringFilter(titleString)
+  filters["capitalize"] = stringFilter(func(s string) string {
+    if s == "" {
+      return s
+    }
+    r, size := utf8.DecodeRuneInString(s)
+    return string(unicode.ToUpper(r)) + strings.ToLower(s[size:])
+  })
+  filters["string"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      s, err := VariableResToString(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      return VariableType{StringResultType(args[0]), s}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["trim"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      s, err := VariableResToString(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      if args[1].Type == PY_TYPE_NONE {
+        s = strings.TrimSpace(s)
+      } else {
+        chars, err := VariableResToString(args[1])
+        if err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        s = strings.Trim(s, chars)
+      }
+      return VariableType{StringResultType(args[0]), s}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"chars", VariableType{PY_TYPE_NONE, nil},},
+    },
+  }
+  filters["replace"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      strs := make([]string, 3)
+      for idx, _ := range strs {
+        s, err := VariableResToString(args[idx])
+        if err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        // replacing inside markup has to keep it safe, so the
+        // replacement strings are escaped unless already markup
+        if idx > 0 && args[0].Type == PY_TYPE_MARKUP && args[idx].Type != PY_TYPE_MARKUP {
+          s = EscapeString(s)
+        }
+        strs[idx] = s
+      }
+      count := -1
+      if args[3].Type != PY_TYPE_NONE {
+        n, err := args[3].AsInt()
+        if err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        count = int(n)
+      }
+      return VariableType{StringResultType(args[0]), strings.Replace(strs[0], strs[1], strs[2], count)}, nil
+    }, []CallableArg {
+      {"s", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"old", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"new", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"count", VariableType{PY_TYPE_NONE, nil},},
+    },
+  }
+  filters["truncate"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      strs, err := argStrings([]VariableType{args[0], args[3]})
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      s, end := []rune(strs[0]), strs[1]
+      length, err := args[1].AsInt()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      killwords, err := args[2].AsBool()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      leeway := int64(5)
+      if args[4].Type != PY_TYPE_NONE {
+        if leeway, err = args[4].AsInt(); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+      }
+      end_length := int64(utf8.RuneCountInString(end))
+      if length < end_length {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("expected length >= " + strconv.FormatInt(end_length, 10) + ", got " + strconv.FormatInt(length, 10))
+      }
+      if int64(len(s)) <= length + leeway {
+        return args[0], nil
+      }
+      if args[0].Type == PY_TYPE_MARKUP && args[3].Type != PY_TYPE_MARKUP {
+        end = EscapeString(end)
+      }
+      res := string(s[:length - end_length])
+      if !killwords {
+        // drop the last word, which was cut off
+        if idx := strings.LastIndex(res, " "); idx != -1 {
+          res = res[:idx]
+        }
+      }
+      return VariableType{StringResultType(args[0]), res + end}, nil
+    }, []CallableArg {
+      {"s", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"length", VariableType{PY_TYPE_INT, int64(255)},},
+      {"killwords", VariableType{PY_TYPE_BOOL, false},},
+      {"end", VariableType{PY_TYPE_STRING, "..."},},
+      {"leeway", VariableType{PY_TYPE_NONE, nil},},
+    },
+  }
+  filters["wordwrap"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      s, err := VariableResToString(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      width, err := args[1].AsInt()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      if width < 1 {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("invalid width " + strconv.FormatInt(width, 10) + " (must be > 0)")
+      }
+      flags, err := argBools([]VariableType{args[2], args[4]})
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      wrapstring := "\n"
+      if args[3].Type != PY_TYPE_NONE {
+        if wrapstring, err = VariableResToString(args[3]); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+      }
+      lines := make([]string, 0)
+      for _, line := range splitLines(s) {
+        lines = append(lines, strings.Join(wrapLine(line, int(width), flags[0], flags[1]), wrapstring))
+      }
+      return VariableType{StringResultType(args[0]), strings.Join(lines, wrapstring)}, nil
+    }, []CallableArg {
+      {"s", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"width", VariableType{PY_TYPE_INT, int64(79)},},
+      {"break_long_words", VariableType{PY_TYPE_BOOL, true},},
+      {"wrapstring", VariableType{PY_TYPE_NONE, nil},},
+      {"break_on_hyphens", VariableType{PY_TYPE_BOOL, true},},
+    },
+  }
+  filters["wordcount"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      s, err := VariableResToString(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      return VariableType{PY_TYPE_INT, int64(len(word_re.FindAllString(s, -1)))}, nil
+    }, []CallableArg {
+      {"s", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["center"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      c := args[0].Data.(*Context)
+      s, err := VariableResToString(args[1])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      width, err := args[2].AsInt()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      margin := width - int64(utf8.RuneCountInString(s))
+      if margin <= 0 {
+        return VariableType{StringResultType(args[1]), s}, nil
+      }
+      // the padding can't be more than the output the render has left
+      if err := c.budget.reserve(margin); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      // the odd space goes on the same side as python's str.center
+      left := int(margin / 2 + (margin & width & 1))
+      s = strings.Repeat(" ", left) + s + strings.Repeat(" ", int(margin) - left)
+      return VariableType{StringResultType(args[1]), s}, nil
+    }, []CallableArg {
+      {"context", VariableType{PY_TYPE_CONTEXT, nil},},
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"width", VariableType{PY_TYPE_INT, int64(80)},},
+    },
+  }
+  filters["indent"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      c := args[0].Data.(*Context)
+      s, err := VariableResToString(args[1])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      lines := splitLines(s + "\n")
+      // the width is either a number of spaces or the string to indent
+      // with, and the indention can't be more than the output the
+      // render has left
+      indention := ""
+      if args[2].Type == PY_TYPE_STRING || args[2].Type == PY_TYPE_MARKUP {
+        indention, _ = args[2].AsString()
+      } else if width, err := args[2].AsInt(); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      } else if width < 0 {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("invalid width " + strconv.FormatInt(width, 10) + " (must be >= 0)")
+      } else if err := c.budget.reserve(width); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      } else {
+        indention = strings.Repeat(" ", int(width))
+      }
+      if err := c.budget.reserve(int64(len(indention)) * int64(len(lines))); err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      flags, err := argBools([]VariableType{args[3], args[4]})
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      first, blank := flags[0], flags[1]
+      res := ""
+      if blank {
+        res = strings.Join(lines, "\n" + indention)
+      } else {
+        res = lines[0]
+        for _, line := range lines[1:] {
+          res += "\n"
+          if line != "" {
+            res += indention + line
+          }
+        }
+      }
+      if first {
+        res = indention + res
+      }
+      return VariableType{StringResultType(args[1]), res}, nil
+    }, []CallableArg {
+      {"context", VariableType{PY_TYPE_CONTEXT, nil},},
+      {"s", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"width", VariableType{PY_TYPE_INT, int64(4)},},
+      {"first", VariableType{PY_TYPE_BOOL, false},},
+      {"blank", VariableType{PY_TYPE_BOOL, false},},
+    },
+  }
+  filters["format"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      s, err := VariableResToString(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      pos_args, _ := args[1].Data.([]VariableType)
+      named_args, _ := args[2].Data.(map[VariableType]VariableType)
+      if len(pos_args) > 0 && len(named_args) > 0 {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("can't handle positional and keyword arguments at the same time")
+      }
+      res, err := percentFormat(s, pos_args, named_args)
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      return VariableType{StringResultType(args[0]), res}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"*args", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"**kwargs", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["striptags"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      s, err := VariableResToString(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      s = strip_comments_re.ReplaceAllString(s, "")
+      s = strip_tags_re.ReplaceAllString(s, "")
+      s = strings.Join(strings.Fields(s), " ")
+      return VariableType{PY_TYPE_STRING, html.UnescapeString(s)}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["urlencode"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      res, err := urlEncode(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      return VariableType{PY_TYPE_STRING, res}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["urlize"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      s, err := VariableResToString(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      limit := -1
+      if args[1].Type != PY_TYPE_NONE {
+        n, err := args[1].AsInt()
+        if err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, err
+        }
+        limit = int(n)
+      }
+      nofollow, err := args[2].AsBool()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      attrs := make([]string, 0)
+      for _, arg := range []VariableType{args[4], args[3]} {
+        v := ""
+        if arg.Type != PY_TYPE_NONE {
+          if v, err = VariableResToString(arg); err != nil {
+            return VariableType{PY_TYPE_UNDEFINED, nil}, err
+          }
+        }
+        attrs = append(attrs, v)
+      }
+      // the rel attribute always has noopener, as in jinja2's default
+      // policy, along with nofollow if asked for
+      rel := strings.Fields(attrs[0])
+      rel = append(rel, "noopener")
+      if nofollow {
+        rel = append(rel, "nofollow")
+      }
+      if args[0].Type != PY_TYPE_MARKUP {
+        s = EscapeString(s)
+      }
+      return VariableType{PY_TYPE_MARKUP, urlize(s, limit, uniqueSorted(rel), attrs[1])}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"trim_url_limit", VariableType{PY_TYPE_NONE, nil},},
+      {"nofollow", VariableType{PY_TYPE_BOOL, false},},
+      {"target", VariableType{PY_TYPE_NONE, nil},},
+      {"rel", VariableType{PY_TYPE_NONE, nil},},
+    },
+  }
+}
+
+var (
+  word_re = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+  strip_comments_re = regexp.MustCompile(`(?s)<!--.*?-->`)
+  strip_tags_re = regexp.MustCompile(`(?s)<.*?>`)
+  whitespace_split_re = regexp.MustCompile(`\s+`)
+  url_lead_re = regexp.MustCompile(`^([(<]|&lt;)+`)
+  url_trail_re = regexp.MustCompile(`([)>.,\n]|&gt;)+$`)
+  url_http_re = regexp.MustCompile(`(?i)^((https?://|www\.)(([\w%-]+\.)+)?([a-z]{2,63}|xn--[\w%]{2,59})|([\w%-]{2,63}\.)+(com|net|int|edu|gov|org|info|mil)|(https?://)(\d{1,3}(\.\d{1,3}){3}))(:\d{1,5})?([/?#]\S*)?$`)
+  url_email_re = regexp.MustCompile(`^\S+@\w[\w.-]*\.\w+$`)
+)
+
+// titleString upper cases the first letter of each word and lower
+// cases the rest, where words start after whitespace, a dash or an
+// opening bracket.
+func titleString(s string) string {
+  var res strings.Builder
+  start := true
+  for _, r := range s {
+    if unicode.IsSpace(r) || strings.ContainsRune("-({[<", r) {
+      start = true
+      res.WriteRune(r)
+    } else if start {
+      start = false
+      res.WriteRune(unicode.ToUpper(r))
+    } else {
+      res.WriteRune(unicode.ToLower(r))
+    }
+  }
+  return res.String()
+}
+
+// splitLines splits on line endings the way python's str.splitlines
+// does, so a trailing line ending doesn't add an empty line.
+func splitLines(s string) []string {
+  s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+  lines := strings.Split(s, "\n")
+  if len(lines) > 1 && lines[len(lines)-1] == "" {
+    lines = lines[:len(lines)-1]
+  }
+  return lines
+}
+
+// wrapLine wraps a line to the width the same way as python's textwrap,
+// which the wordwrap filter in jinja2 uses.
+func wrapLine(line string, width int, break_long_words bool, break_on_hyphens bool) []string {
+  type chunk struct {
+    text []rune
+    spaced bool
+  }
+  chunks := make([]chunk, 0)
+  for _, word := range strings.Fields(line) {
+    parts := []string{word}
+    if break_on_hyphens {
+      parts = splitHyphens(word)
+    }
+    for idx, part := range parts {
+      chunks = append(chunks, chunk{[]rune(part), idx == 0})
+    }
+  }
+  lines := make([]string, 0)
+  cur := make([]rune, 0)
+  for idx := 0; idx < len(chunks); idx++ {
+    c := chunks[idx]
+    sep := 0
+    if c.spaced && len(cur) > 0 {
+      sep = 1
+    }
+    if len(cur) + sep + len(c.text) <= width {
+      if sep == 1 {
+        cur = append(cur, ' ')
+      }
+      cur = append(cur, c.text...)
+      continue
+    }
+    if len(c.text) > width && break_long_words {
+      // fill what is left of the line with the start of the word,
+      // and carry on with the rest of it
+      space_left := width - len(cur) - sep
+      if space_left < 1 {
+        lines = append(lines, string(cur))
+        cur, sep, space_left = make([]rune, 0), 0, width
+        if space_left < 1 {
+          space_left = 1
+        }
+      }
+      if sep == 1 {
+        cur = append(cur, ' ')
+      }
+      cur = append(cur, c.text[:space_left]...)
+      lines = append(lines, string(cur))
+      cur = make([]rune, 0)
+      chunks[idx] = chunk{c.text[space_left:], false}
+      idx -= 1
+      continue
+    }
+    if len(cur) > 0 {
+      lines = append(lines, string(cur))
+    }
+    cur = append(make([]rune, 0), c.text...)
+  }
+  if len(cur) > 0 {
+    lines = append(lines, string(cur))
+  }
+  return lines
+}
+
+// splitHyphens splits a word after each hyphen between two letters.
+func splitHyphens(word string) []string {
+  res := make([]string, 0)
+  runes := []rune(word)
+  start := 0
+  for idx := 1; idx < len(runes) - 1; idx++ {
+    if runes[idx] == '-' && unicode.IsLetter(runes[idx-1]) && unicode.IsLetter(runes[idx+1]) {
+      res = append(res, string(runes[start:idx+1]))
+      start = idx + 1
+    }
+  }
+  return append(res, string(runes[start:]))
+}
+
+// percentFormat formats the values into the string with python's %
+// operator, taking the values by name when there are named args.
+func percentFormat(format string, pos_args []VariableType, named_args map[VariableType]VariableType) (string, error) {
+  var res strings.Builder
+  next := 0
+  for idx := 0; idx < len(format); idx++ {
+    if format[idx] != '%' {
+      res.WriteByte(format[idx])
+      continue
+    }
+    idx += 1
+    if idx < len(format) && format[idx] == '%' {
+      res.WriteByte('%')
+      continue
+    }
+    var arg VariableType
+    if idx < len(format) && format[idx] == '(' {
+      end := strings.IndexByte(format[idx:], ')')
+      if end == -1 {
+        return "", errors.New("incomplete format key")
+      }
+      v, ok := named_args[VariableType{PY_TYPE_STRING, format[idx+1:idx+end]}]
+      if !ok {
+        return "", errors.New("format key '" + format[idx+1:idx+end] + "' was not found")
+      }
+      arg = v
+      idx += end + 1
+    } else {
+      if next >= len(pos_args) {
+        return "", errors.New("not enough arguments for format string")
+      }
+      arg = pos_args[next]
+      next += 1
+    }
+    // the flags, width and precision are the same as in go
+    spec_start := idx
+    for idx < len(format) && strings.IndexByte("#0- +.0123456789", format[idx]) != -1 {
+      idx += 1
+    }
+    if idx >= len(format) {
+      return "", errors.New("incomplete format")
+    }
+    spec := format[spec_start:idx]
+    s, err := formatValue(arg, spec, format[idx])
+    if err != nil {
+      return "", err
+    }
+    res.WriteString(s)
+  }
+  if next < len(pos_args) {
+    return "", errors.New("not all arguments converted during string formatting")
+  }
+  return res.String(), nil
+}
+
+func formatValue(v VariableType, spec string, verb byte) (string, error) {
+  switch verb {
+  case 's', 'r':
+    s, err := VariableResToString(v)
+    if err != nil {
+      return "", err
+    }
+    if verb == 'r' && (v.Type == PY_TYPE_STRING || v.Type == PY_TYPE_MARKUP) {
+      s = "'" + s + "'"
+    }
+    return fmt.Sprintf("%" + spec + "s", s), nil
+  case 'd', 'i', 'x', 'X', 'o', 'c':
+    n, err := v.AsInt()
+    if err != nil {
+      return "", errors.New("%" + string(verb) + " format: a number is required, not " + PyTypeToString(v.Type))
+    }
+    if verb == 'i' {
+      verb = 'd'
+    }
+    return fmt.Sprintf("%" + spec + string(verb), n), nil
+  case 'f', 'F', 'e', 'E', 'g', 'G':
+    f, err := v.AsFloat()
+    if err != nil {
+      return "", errors.New("%" + string(verb) + " format: a number is required, not " + PyTypeToString(v.Type))
+    }
+    if !strings.Contains(spec, ".") && verb != 'g' && verb != 'G' {
+      // python defaults to a precision of 6, the same as go
+      spec += ".6"
+    }
+    return fmt.Sprintf("%" + spec + strings.ToLower(string(verb)), f), nil
+  }
+  return "", errors.New("unsupported format character '" + string(verb) + "'")
+}
+
+// urlQuote percent encodes everything but the characters python's
+// quote leaves alone. In a query string, spaces become '+' and '/' is
+// encoded too.
+func urlQuote(s string, for_qs bool) string {
+  var res strings.Builder
+  for idx := 0; idx < len(s); idx++ {
+    b := s[idx]
+    if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || strings.IndexByte("_.-~", b) != -1 || b == '/' && !for_qs {
+      res.WriteByte(b)
+    } else if b == ' ' && for_qs {
+      res.WriteByte('+')
+    } else {
+      res.WriteString("%" + strings.ToUpper(strconv.FormatInt(int64(b) | 0x100, 16)[1:]))
+    }
+  }
+  return res.String()
+}
+
+// urlEncode quotes a string for use in a URL path, or makes a query
+// string from a dict or a list of pairs. Dicts are sorted by key so
+// the result doesn't change from one render to the next.
+func urlEncode(v VariableType) (string, error) {
+  pairs := make([][]VariableType, 0)
+  switch v.Type {
+  case PY_TYPE_DICT:
+    for k, item := range v.Data.(map[VariableType]VariableType) {
+      pairs = append(pairs, []VariableType{k, item})
+    }
+  case PY_TYPE_LIST, PY_TYPE_TUPLE:
+    items, _ := v.AsList()
+    for _, item := range items {
+      pair, err := item.AsList()
+      if err != nil || len(pair) != 2 {
+        return "", errors.New("urlencode needs a list of key and value pairs")
+      }
+      pairs = append(pairs, pair)
+    }
+  default:
+    s, err := VariableResToString(v)
+    if err != nil {
+      return "", err
+    }
+    return urlQuote(s, false), nil
+  }
+  parts := make([]string, len(pairs))
+  for idx, pair := range pairs {
+    strs, err := argStrings(pair)
+    if err != nil {
+      return "", err
+    }
+    parts[idx] = urlQuote(strs[0], true) + "=" + urlQuote(strs[1], true)
+  }
+  if v.Type == PY_TYPE_DICT {
+    sort.Strings(parts)
+  }
+  return strings.Join(parts, "&"), nil
+}
+
+func uniqueSorted(items []string) []string {
+  sort.Strings(items)
+  res := make([]string, 0)
+  for idx, item := range items {
+    if idx == 0 || item != items[idx-1] {
+      res = append(res, item)
+    }
+  }
+  return res
+}
+
+// urlize turns the URLs and email addresses in the already escaped text
+// into links, the same way as jinja2.
+func urlize(text string, limit int, rel []string, target string) string {
+  attrs := ""
+  if len(rel) > 0 {
+    attrs += ` rel="` + EscapeString(strings.Join(rel, " ")) + `"`
+  }
+  if target != "" {
+    attrs += ` target="` + EscapeString(target) + `"`
+  }
+  trim := func(url string) string {
+    if limit >= 0 && len(url) > limit {
+      return url[:limit] + "..."
+    }
+    return url
+  }
+  var res strings.Builder
+  last := 0
+  for _, loc := range whitespace_split_re.FindAllStringIndex(text, -1) {
+    res.WriteString(urlizeWord(text[last:loc[0]], attrs, trim))
+    res.WriteString(text[loc[0]:loc[1]])
+    last = loc[1]
+  }
+  res.WriteString(urlizeWord(text[last:], attrs, trim))
+  return res.String()
+}
+
+func urlizeWord(word string, attrs string, trim func(string) string) string {
+  head, middle, tail := "", word, ""
+  if m := url_lead_re.FindString(middle); m != "" {
+    head, middle = m, middle[len(m):]
+  }
+  if loc := url_trail_re.FindStringIndex(middle); loc != nil {
+    tail, middle = middle[loc[0]:], middle[:loc[0]]
+  }
+  // balance brackets which are closed in the trailing punctuation
+  for _, pair := range [][2]string{{"(", ")"}, {"<", ">"}, {"&lt;", "&gt;"}} {
+    start_count := strings.Count(middle, pair[0])
+    if start_count <= strings.Count(middle, pair[1]) {
+      continue
+    }
+    for n := 0; n < start_count && strings.Contains(tail, pair[1]); n++ {
+      end := strings.Index(tail, pair[1]) + len(pair[1])
+      middle, tail = middle + tail[:end], tail[end:]
+    }
+  }
+  if url_http_re.MatchString(middle) {
+    href := middle
+    if !strings.HasPrefix(middle, "https://") && !strings.HasPrefix(middle, "http://") {
+      href = "https://" + middle
+    }
+    middle = `<a href="` + href + `"` + attrs + `>` + trim(middle) + `</a>`
+  } else if strings.HasPrefix(middle, "mailto:") && url_email_re.MatchString(middle[7:]) {
+    middle = `<a href="` + middle + `">` + middle[7:] + `</a>`
+  } else if strings.Contains(middle, "@") && !strings.HasPrefix(middle, "www.") && !strings.Contains(middle, ":") && url_email_re.MatchString(middle) {
+    middle = `<a href="mailto:` + middle + `">` + middle + `</a>`
+  }
+  return head + middle + tail
+}
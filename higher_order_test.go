@@ -0,0 +1,187 @@
+package jinja2
+
+import (
+  "strings"
+  "testing"
+)
+
+func higherOrderVars() map[string]interface{} {
+  return map[string]interface{}{
+    "users": []interface{}{
+      map[interface{}]interface{}{"id": 1, "name": "john", "is_active": true},
+      map[interface{}]interface{}{"id": 2, "name": "jane", "is_active": false, "lastname": "doe"},
+      map[interface{}]interface{}{"id": 3, "name": "mike", "is_active": true},
+    },
+    "items": []interface{}{
+      map[interface{}]interface{}{"foo": 1, "bar": 2},
+      map[interface{}]interface{}{"foo": 2, "bar": 3},
+      map[interface{}]interface{}{"foo": 1, "bar": 1},
+      map[interface{}]interface{}{"foo": 3, "bar": 4},
+    },
+    "letters": []interface{}{
+      map[interface{}]interface{}{"k": "a", "v": 1},
+      map[interface{}]interface{}{"k": "A", "v": 2},
+      map[interface{}]interface{}{"k": "b", "v": 3},
+    },
+  }
+}
+
+func TestMapFilter(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), higherOrderVars(), map[string]string{
+    `{{ ["1", "2", "3"]|map("int")|sum }}`: "6",
+    `{{ ["a", "b"]|map("upper")|join }}`: "AB",
+    `{{ ["a-b", "c"]|map("replace", "-", "+")|join(",") }}`: "a+b,c",
+    `{{ ["abc", "d"]|map("truncate", length=3, end="", leeway=0)|join(",") }}`: "abc,d",
+    `{{ users|map(attribute="name")|join("|") }}`: "john|jane|mike",
+    `{{ users|map(attribute="lastname", default="smith")|join(",") }}`: "smith,doe,smith",
+    `[{{ users|map(attribute="lastname")|join(",") }}]`: "[,doe,]",
+    `{{ [[1, 2], [3]]|map("first")|list }}`: "[1, 3]",
+  })
+}
+
+func TestSelectFilters(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), higherOrderVars(), map[string]string{
+    `{{ [1, 2, 3, 4, 5]|select("odd")|join("|") }}`: "1|3|5",
+    `{{ [1, 2, 3, 4, 5]|reject("odd")|join("|") }}`: "2|4",
+    `{{ [false, 0, 1, 2, "", "a"]|select|join("|") }}`: "1|2|a",
+    `{{ [false, 0, 1]|reject|length }}`: "2",
+    `{{ [1, 2, 3, 4, 5, 6]|select("divisibleby", 3)|join("|") }}`: "3|6",
+    `{{ [1, 2, 3]|select(">", 1)|join("|") }}|{{ [1, 2, 3]|reject("lessthan", 3)|join("|") }}`: "2|3|3",
+    `{{ ["a", "b", "c"]|select("in", "abba")|join }}`: "ab",
+    `{{ users|selectattr("is_active")|join("|", attribute="name") }}`: "john|mike",
+    `{{ users|rejectattr("is_active")|join("|", attribute="name") }}`: "jane",
+    `{{ users|selectattr("id", "equalto", 2)|join("|", attribute="name") }}`: "jane",
+    `{{ users|rejectattr("id", "==", 2)|join("|", attribute="name") }}`: "john|mike",
+    `{{ users|selectattr("lastname", "defined")|join("|", attribute="name") }}`: "jane",
+    `{{ users|selectattr("lastname", "undefined")|map(attribute="id")|sum }}`: "4",
+  })
+}
+
+func TestGroupbyFilter(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), higherOrderVars(), map[string]string{
+    `{% for group in items|groupby("foo") %}{{ group.grouper }}: {{ group.list|join(", ", attribute="bar") }}|{% endfor %}`: "1: 2, 1|2: 3|3: 4|",
+    `{% for group in letters|groupby("k") %}{{ group.grouper }}={{ group.list|map(attribute="v")|join(",") }};{% endfor %}`: "a=1,2;b=3;",
+    `{% for group in letters|groupby("k", case_sensitive=true) %}{{ group.grouper }}={{ group.list|map(attribute="v")|join(",") }};{% endfor %}`: "A=2;a=1;b=3;",
+    `{% for group in users|groupby("lastname", default="-") %}{{ group.grouper }}:{{ group.list|length }};{% endfor %}`: "-:2;doe:1;",
+    `{% for foo, group in items|groupby("foo") %}{{ foo }}={{ group|map(attribute="bar")|join(",") }};{% endfor %}`: "1=2,1;2=3;3=4;",
+    `{{ items|groupby("foo")|map(attribute="grouper")|join(",") }}|{{ items|groupby("foo")|map("first")|join(",") }}`: "1,2,3|1,2,3",
+    `{% for group in letters|groupby("k") %}{{ group|length }}{{ group.nope is defined }}{% endfor %}`: "2false2false",
+    `{{ [{"a": 1}]|groupby("a")|map(attribute="list")|first|first|length }}`: "1",
+    `{{ [{"a": 1}]|groupby("a")|first }}`: "(1, [{'a': 1}])",
+    `{% for key, group in users|groupby("zzz") %}[{{ key }}]:{{ group|length }};{% endfor %}`: "[]:3;",
+    `{% for key, group in users|groupby("zzz", default="none") %}{{ key }}:{{ group|length }};{% endfor %}`: "none:3;",
+  })
+  env := NewEnvironment(nil)
+  env.Undefined = UNDEFINED_STRICT
+  for source, expected := range map[string]string{
+    `{% for key, group in users|groupby("zzz") %}{{ key }};{% endfor %}`: "",
+    `{% for key, group in [{"a": 1}]|groupby("zzz") %}{{ key }};{% endfor %}`: "",
+    `{% for key, group in users|groupby("zzz", default="none") %}{{ key }}:{{ group|length }};{% endfor %}`: "none:3;",
+    `{% for key, group in users|groupby("lastname") %}{{ key }};{% endfor %}`: "",
+    `{{ users|map(attribute="zzz")|join }}`: "",
+    `{{ users|selectattr("zzz")|list }}`: "",
+    `{{ users|map(attribute="zzz", default=1)|sum }}`: "3",
+  } {
+    template, err := env.FromString(source)
+    if err != nil {
+      t.Fatalf("error parsing template: %v", err)
+    }
+    res, err := template.Render(env.NewContext(higherOrderVars()))
+    if expected == "" && !IsUndefinedError(err) {
+      t.Errorf("expected an undefined error rendering '%s', got: %v", source, err)
+    } else if expected != "" && (err != nil || res != expected) {
+      t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, expected)
+    }
+  }
+}
+
+func TestBuiltinTests(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), nil, map[string]string{
+    `{{ 3 is odd }} {{ 3 is even }} {{ 9 is divisibleby(3) }}`: "true false true",
+    `{{ 1 is none }} {{ 1 is number }} {{ "a" is string }} {{ {} is mapping }} {{ [] is sequence }}`: "false true true true true",
+    `{{ 1 is eq(1) }} {{ 1 is ne(1) }} {{ 1 is lt(2) }} {{ "b" is gt("a") }} {{ 2 is ge(2) }} {{ 2 is le(1) }}`: "true false true true true false",
+    `{{ [1, 2] is eq([1, 2]) }} {{ 1 is eq("1") }} {{ 1 is eq(1.0) }}`: "true false true",
+    `{{ 2 is in([1, 2]) }} {{ "b" is in("abc") }} {{ "x" is in({"x": 1}) }} {{ 3 is not in([1]) }}`: "true true true true",
+    `{{ "abc" is lower }} {{ "ABC" is upper }} {{ true is true }} {{ "<b>"|safe is escaped }}`: "true true true true",
+  })
+  for _, source := range []string{`{{ "a" is odd }}`, `{{ 1 is lt("a") }}`, `{{ 1 is divisibleby(0) }}`} {
+    if err := renderUndefinedError(t, NewEnvironment(nil), source); err == nil {
+      t.Errorf("expected an error rendering '%s'", source)
+    }
+  }
+}
+
+func TestFilterWithContext(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.Globals["greeting"] = VariableType{PY_TYPE_STRING, "hello"}
+  env.Filters["greet"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      c := args[0].Data.(*Context)
+      greeting, _ := c.LookupVariable("greeting")
+      name, _ := VariableResToString(args[1])
+      return VariableType{PY_TYPE_STRING, greeting.Data.(string) + " " + name}, nil
+    }, []CallableArg {
+      {"context", VariableType{PY_TYPE_CONTEXT, nil},},
+      {"name", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  checkFilterResults(t, env, map[string]interface{}{"names": []interface{}{"a", "b"}}, map[string]string{
+    `{{ "bob"|greet }}`: "hello bob",
+    `{{ names|map("greet")|join(", ") }}`: "hello a, hello b",
+  })
+  for _, source := range []string{`{{ "bob"|greet(context=1) }}`, `{{ "bob"|greet(1) }}`} {
+    if err := renderUndefinedError(t, env, source); err == nil {
+      t.Errorf("expected an error rendering '%s'", source)
+    }
+  }
+}
+
+func TestHigherOrderFilterErrors(t *testing.T) {
+  env := NewEnvironment(nil)
+  for source, message := range map[string]string{
+    `{{ [1]|map("nope") }}`: "the filter 'nope' was not found.",
+    `{{ [1]|select("nope") }}`: "the test 'nope' was not found.",
+    `{{ [1]|map }}`: "map requires a filter or an attribute",
+    `{{ [1]|selectattr }}`: "Missing parameter for attribute name",
+  } {
+    err := renderUndefinedError(t, env, source)
+    if err == nil || !strings.Contains(err.Error(), message) {
+      t.Errorf("expected the error '%s' rendering '%s', got: %v", message, source, err)
+    }
+  }
+
+  // the filters and tests used by name are checked by the sandbox
+  sandbox := NewSandboxedEnvironment(nil)
+  sandbox.AllowedFilters = []string{"map", "select", "join"}
+  sandbox.AllowedTests = []string{"odd"}
+  if res := renderWithEnv(t, sandbox.Environment, `{{ [1, 2, 3]|select("odd")|join }}`, nil); res != "13" {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, "13")
+  }
+  for _, source := range []string{`{{ ["a"]|map("upper")|join }}`, `{{ [1]|select("even")|join }}`} {
+    if err := renderUndefinedError(t, sandbox.Environment, source); !IsSecurityError(err) {
+      t.Errorf("expected a security error rendering '%s', got: %v", source, err)
+    }
+  }
+
+  // as are the attributes the filters look up on the items
+  sandbox = NewSandboxedEnvironment(nil)
+  vars := map[string]interface{}{
+    "users": []interface{}{
+      map[interface{}]interface{}{"name": "john", "_password": "s1", "profile": map[interface{}]interface{}{"_key": 1}},
+    },
+  }
+  if res, err := renderSandboxed(t, sandbox, `{{ users|selectattr("name", "equalto", "john")|map(attribute="name")|join }}`, vars); err != nil || res != "john" {
+    t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, "john")
+  }
+  for _, source := range []string{
+    `{{ users|map(attribute="_password")|join }}`,
+    `{{ users|map(attribute="profile._key", default=0)|join }}`,
+    `{{ users|selectattr("_password", "equalto", "s1")|list }}`,
+    `{{ users|rejectattr("_password")|list }}`,
+    `{% for group in users|groupby("_password") %}{{ group.grouper }}{% endfor %}`,
+  } {
+    if _, err := renderSandboxed(t, sandbox, source, vars); !IsSecurityError(err) {
+      t.Errorf("expected a security error rendering '%s', got: %v", source, err)
+    }
+  }
+}
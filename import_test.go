@@ -0,0 +1,58 @@
+package jinja2
+
+import (
+  "testing"
+)
+
+var formsTemplate = `{% macro input(name, type="text") %}<input type={{ type }} name={{ name }}>{% endmacro %}` +
+  `{% macro label(text) %}<label>{{ text }}</label>{% endmacro %}` +
+  `{% macro _private() %}secret{% endmacro %}` +
+  `{% macro site_name() %}{{ site }}{% endmacro %}`
+
+func TestImportAs(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "forms.html": formsTemplate,
+    "page.html": `{% import "forms.html" as forms %}{{ forms.label("Name") }}{{ forms.input("name") }}`,
+  }))
+  expected := "<label>Name</label><input type=text name=name>"
+  if res := renderFromEnv(t, env, "page.html", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestFromImport(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "forms.html": formsTemplate,
+    "page.html": `{% from "forms.html" import input, label as lbl %}{{ lbl("Pass") }}{{ input("pass", type="password") }}`,
+    "private.html": `{% from "forms.html" import _private %}`,
+    "missing.html": `{% from "forms.html" import textarea %}`,
+  }))
+  expected := "<label>Pass</label><input type=password name=pass>"
+  if res := renderFromEnv(t, env, "page.html", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  for _, name := range []string{"private.html", "missing.html"} {
+    template, _ := env.GetTemplate(name)
+    if _, err := template.Render(env.NewContext(nil)); err == nil {
+      t.Errorf("expected an error importing from '%s'", name)
+    }
+  }
+}
+
+func TestImportContext(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "forms.html": formsTemplate,
+    "with.html": `{% import "forms.html" as forms with context %}{{ forms.site_name() }}`,
+    "without.html": `{% from "forms.html" import site_name %}{{ site_name() }}`,
+  }))
+  vars := map[string]interface{}{"site": "example"}
+  expected := "example"
+  if res := renderFromEnv(t, env, "with.html", vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  env.Undefined = UNDEFINED_STRICT
+  template, _ := env.GetTemplate("without.html")
+  if _, err := template.Render(env.NewContext(vars)); err == nil {
+    t.Errorf("expected an error using a context variable in a macro imported without context")
+  }
+}
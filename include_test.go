@@ -0,0 +1,74 @@
+package jinja2
+
+import (
+  "testing"
+)
+
+func TestInclude(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "header.html": "<h1>{{ title }}</h1>",
+    "page.html": `{% include "header.html" %}body`,
+    "loop.html": `{% for title in seq %}{% include "header.html" %}{% endfor %}`,
+  }))
+  expected := "<h1>Hello</h1>body"
+  if res := renderFromEnv(t, env, "page.html", map[string]interface{}{"title": "Hello"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  expected = "<h1>a</h1><h1>b</h1>"
+  if res := renderFromEnv(t, env, "loop.html", map[string]interface{}{"seq": []interface{}{"a", "b"}}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestIncludeContext(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "partial.html": "{{ name is defined }}",
+    "with.html": `{% include "partial.html" with context %}`,
+    "without.html": `{% include "partial.html" without context %}`,
+  }))
+  vars := map[string]interface{}{"name": "x"}
+  expected := "true"
+  if res := renderFromEnv(t, env, "with.html", vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  expected = "false"
+  if res := renderFromEnv(t, env, "without.html", vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestIncludeMissing(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "fallback.html": "fallback",
+    "ignore.html": `[{% include "missing.html" ignore missing %}]`,
+    "choice.html": `{% include ["missing.html", "fallback.html"] %}`,
+    "error.html": `{% include ["missing.html", "other.html"] %}`,
+  }))
+  expected := "[]"
+  if res := renderFromEnv(t, env, "ignore.html", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  expected = "fallback"
+  if res := renderFromEnv(t, env, "choice.html", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  template, _ := env.GetTemplate("error.html")
+  if _, err := template.Render(env.NewContext(nil)); !IsTemplateNotFound(err) {
+    t.Errorf("expected a template not found error, got: %v", err)
+  }
+}
+
+func TestIncludeDoesNotModifyContext(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "partial.html": "{% for leaked in seq %}{% endfor %}",
+    "page.html": `{% include "partial.html" %}`,
+  }))
+  context := env.NewContext(map[string]interface{}{"seq": []interface{}{1}})
+  template, _ := env.GetTemplate("page.html")
+  if _, err := template.Render(context); err != nil {
+    t.Fatalf("error rendering template: %v", err)
+  }
+  if _, ok := context.Variables["leaked"]; ok {
+    t.Errorf("rendering an include modified the variables of the context")
+  }
+}
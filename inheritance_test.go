@@ -0,0 +1,77 @@
+package jinja2
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestExtendsBlocks(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "base.html": "<title>{% block title %}Base{% endblock %}</title>{% block body %}empty{% endblock body %}",
+    "child.html": `{% extends "base.html" %}ignored{% block title %}Child{% endblock %}`,
+  }))
+  expected := "<title>Child</title>empty"
+  if res := renderFromEnv(t, env, "child.html", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestExtendsMultiLevelSuper(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "base.html": "[{% block content %}base{% endblock %}]",
+    "middle.html": `{% extends "base.html" %}{% block content %}middle+{{ super() }}{% endblock %}`,
+    "child.html": `{% extends "middle.html" %}{% block content %}child+{{ super() }}{% endblock %}`,
+  }))
+  expected := "[child+middle+base]"
+  if res := renderFromEnv(t, env, "child.html", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestNestedBlocksAndSelf(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "base.html": "{% block outer %}<{% block inner %}base{% endblock %}>{% endblock %}|{{ self.inner() }}",
+    "child.html": `{% extends "base.html" %}{% block inner %}child{% endblock %}`,
+  }))
+  expected := "<child>|child"
+  if res := renderFromEnv(t, env, "child.html", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestScopedBlock(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "scoped.html": "{% for item in seq %}{% block entry scoped %}{{ item }}{% endblock %}{% endfor %}",
+    "unscoped.html": "{% for item in seq %}{% block entry %}{{ item }}{% endblock %}{% endfor %}",
+  }))
+  vars := map[string]interface{}{"seq": []interface{}{1, 2, 3}}
+  expected := "123"
+  if res := renderFromEnv(t, env, "scoped.html", vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  env.Undefined = UNDEFINED_STRICT
+  template, _ := env.GetTemplate("unscoped.html")
+  if _, err := template.Render(env.NewContext(vars)); err == nil {
+    t.Errorf("expected an error when an unscoped block uses a loop variable")
+  }
+}
+
+func TestRequiredBlock(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "base.html": "{% block body required %}{% endblock %}",
+    "good.html": `{% extends "base.html" %}{% block body %}ok{% endblock %}`,
+    "bad.html": `{% extends "base.html" %}`,
+    "invalid.html": "{% block body required %}content{% endblock %}",
+  }))
+  expected := "ok"
+  if res := renderFromEnv(t, env, "good.html", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  template, _ := env.GetTemplate("bad.html")
+  if _, err := template.Render(env.NewContext(nil)); err == nil || !strings.Contains(err.Error(), "required block 'body'") {
+    t.Errorf("expected a required block error, got: %v", err)
+  }
+  if _, err := env.GetTemplate("invalid.html"); err == nil {
+    t.Errorf("expected an error for a required block with content")
+  }
+}
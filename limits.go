@@ -0,0 +1,145 @@
+package jinja2
+
+import (
+  "context"
+  "errors"
+  "io"
+  "strconv"
+)
+
+//-------------------------------------------------------------------------------------------------
+// Limits bound the work a single render may do, so that a template
+// can't loop forever or produce more output than can be handled. The
+// limits are for the whole render, including any templates it includes
+// or imports. A limit of zero means there is no limit.
+//
+// MaxDepth limits how deeply macros, call blocks, includes, imports and
+// blocks may nest. MaxOutputBytes limits all of the output the render
+// makes, which is the output written along with any output captured for
+// a macro, set or filter block, so captured output which is written out
+// again counts twice. MaxCalls counts every call made by the template,
+// including filters and tests.
+type Limits struct {
+  MaxLoopIterations int64
+  MaxDepth int64
+  MaxOutputBytes int64
+  MaxCalls int64
+}
+
+// LimitExceededError is returned when a render goes over one of its
+// limits.
+type LimitExceededError struct {
+  Limit string
+  Max int64
+}
+func (self *LimitExceededError) Error() string {
+  return "render limit exceeded: the " + self.Limit + " is limited to " + strconv.FormatInt(self.Max, 10)
+}
+
+func IsLimitExceeded(err error) bool {
+  var limit_err *LimitExceededError
+  return errors.As(err, &limit_err)
+}
+
+// The renderBudget tracks the work done by a render against its limits,
+// and holds the context.Context which can cancel it. Every context used
+// during the render shares the same budget. The methods are safe to call
+// on a nil budget, which has no limits.
+type renderBudget struct {
+  ctx context.Context
+  limits Limits
+  iterations int64
+  calls int64
+  depth int64
+  written int64
+}
+
+func newRenderBudget(ctx context.Context, limits Limits) *renderBudget {
+  return &renderBudget{ctx: ctx, limits: limits}
+}
+
+// check returns an error once the render has been cancelled or its
+// deadline has passed.
+func (self *renderBudget) check() error {
+  if self == nil {
+    return nil
+  }
+  return self.ctx.Err()
+}
+
+// iterate counts an iteration of a for loop.
+func (self *renderBudget) iterate() error {
+  if self == nil {
+    return nil
+  }
+  self.iterations += 1
+  if self.limits.MaxLoopIterations > 0 && self.iterations > self.limits.MaxLoopIterations {
+    return &LimitExceededError{"number of loop iterations", self.limits.MaxLoopIterations}
+  }
+  return self.check()
+}
+
+// call counts a call made by the template.
+func (self *renderBudget) call() error {
+  if self == nil {
+    return nil
+  }
+  self.calls += 1
+  if self.limits.MaxCalls > 0 && self.calls > self.limits.MaxCalls {
+    return &LimitExceededError{"number of calls", self.limits.MaxCalls}
+  }
+  return self.check()
+}
+
+// enter goes one level deeper into a macro, include, import or block,
+// and must be matched by a call to leave when it succeeds.
+func (self *renderBudget) enter() error {
+  if self == nil {
+    return nil
+  }
+  if self.limits.MaxDepth > 0 && self.depth >= self.limits.MaxDepth {
+    return &LimitExceededError{"depth", self.limits.MaxDepth}
+  }
+  self.depth += 1
+  return nil
+}
+func (self *renderBudget) leave() {
+  if self != nil {
+    self.depth -= 1
+  }
+}
+
+// reserve returns an error when n more bytes of output would go over
+// the output limit, for filters to check before they build a large
+// string.
+func (self *renderBudget) reserve(n int64) error {
+  if self == nil || self.limits.MaxOutputBytes <= 0 {
+    return nil
+  }
+  if n > self.limits.MaxOutputBytes - self.written {
+    return &LimitExceededError{"output size in bytes", self.limits.MaxOutputBytes}
+  }
+  return nil
+}
+
+// writer wraps a writer so that it fails once the output limit is hit.
+// Every writer from the budget counts against the same limit.
+func (self *renderBudget) writer(w io.Writer) io.Writer {
+  if self == nil || self.limits.MaxOutputBytes <= 0 {
+    return w
+  }
+  return &limitedWriter{w, self}
+}
+
+type limitedWriter struct {
+  w io.Writer
+  budget *renderBudget
+}
+func (self *limitedWriter) Write(p []byte) (int, error) {
+  if err := self.budget.reserve(int64(len(p))); err != nil {
+    return 0, err
+  }
+  n, err := self.w.Write(p)
+  self.budget.written += int64(n)
+  return n, err
+}
@@ -0,0 +1,110 @@
+package jinja2
+
+import (
+  "context"
+  "errors"
+  "testing"
+  "time"
+)
+
+func renderLimited(t *testing.T, env *Environment, name string, vars map[string]interface{}) error {
+  template, err := env.GetTemplate(name)
+  if err != nil {
+    t.Fatalf("error loading template '%s': %v", name, err)
+  }
+  _, err = template.Render(env.NewContext(vars))
+  return err
+}
+
+func TestRenderContextCancelled(t *testing.T) {
+  template := new(Template)
+  if err := template.Parse("{% for i in [1, 2, 3] %}{{ i }}{% endfor %}"); err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  if _, err := template.RenderContext(ctx, NewContext(nil)); !errors.Is(err, context.Canceled) {
+    t.Errorf("expected the render to be cancelled, got: %v", err)
+  }
+  ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+  defer cancel()
+  if _, err := template.RenderContext(ctx, NewContext(nil)); !errors.Is(err, context.DeadlineExceeded) {
+    t.Errorf("expected the render to pass its deadline, got: %v", err)
+  }
+  expected := "123"
+  if res, err := template.RenderContext(context.Background(), NewContext(nil)); err != nil || res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, expected)
+  }
+}
+
+func TestRenderLimits(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "loop.html": "{% for i in items %}{% for j in items %}{{ j }}{% endfor %}{% endfor %}",
+    "macro.html": "{% macro r(n) %}{{ r(n) }}{% endmacro %}{{ r(1) }}",
+    "include.html": "x{% include 'include.html' %}",
+    "output.html": "{% for i in items %}{{ i }}{% endfor %}",
+    "capture.html": "{% set x %}{% for i in items %}{{ i }}{% endfor %}{% endset %}done",
+    "calls.html": "{{ 'a'|upper|upper|upper }}",
+    "text.html": "{{ 'ab' }}\ncdef",
+  }))
+  vars := map[string]interface{}{"items": []interface{}{1, 2, 3, 4, 5}}
+  for name, limits := range map[string]Limits{
+    "loop.html": Limits{MaxLoopIterations: 20},
+    "macro.html": Limits{MaxDepth: 10},
+    "include.html": Limits{MaxDepth: 10},
+    "output.html": Limits{MaxOutputBytes: 4},
+    "capture.html": Limits{MaxOutputBytes: 4},
+    "calls.html": Limits{MaxCalls: 2},
+  } {
+    env.Limits = limits
+    if err := renderLimited(t, env, name, vars); !IsLimitExceeded(err) {
+      t.Errorf("expected a limit to be exceeded rendering '%s', got: %v", name, err)
+    }
+  }
+  env.Limits = Limits{MaxLoopIterations: 30, MaxOutputBytes: 25, MaxCalls: 3}
+  for _, name := range []string{"loop.html", "calls.html"} {
+    if err := renderLimited(t, env, name, vars); err != nil {
+      t.Errorf("error rendering '%s' within its limits: %v", name, err)
+    }
+  }
+
+  // the output limit is reported at the tag which went over it, and
+  // without a position when it was text
+  env.Limits = Limits{MaxOutputBytes: 4}
+  for name, expected := range map[string]string{
+    "output.html": "output.html:1:24: render limit exceeded: the output size in bytes is limited to 4\n  in expression: i",
+    "text.html": "render limit exceeded: the output size in bytes is limited to 4",
+  } {
+    if err := renderLimited(t, env, name, vars); err == nil || err.Error() != expected {
+      t.Errorf("wrong error rendering '%s'. Got: '%v' but expected '%s'", name, err, expected)
+    }
+  }
+}
+
+func TestOutputLimitIsShared(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "set.html": "{% set x %}abcdef{% endset %}{% set y %}abcdef{% endset %}ok",
+    "macro.html": "{% macro m() %}abcdef{% endmacro %}{% set x = m() %}{% set y = m() %}ok",
+    "center.html": "{% set x = 'a'|center(1000) %}ok",
+    "indent.html": "{% set x = 'abc'|indent(40, true) %}ok",
+    "padded.html": "{{ 'a'|center(5) }}{{ 'a\\nb'|indent(2) }}",
+  }))
+  env.Limits = Limits{MaxOutputBytes: 100}
+  if err := renderLimited(t, env, "padded.html", nil); err != nil {
+    t.Errorf("error rendering 'padded.html' within its limits: %v", err)
+  }
+  // each of the captures is within the limit, but not all of them, and
+  // the padding is checked before it is made
+  env.Limits = Limits{MaxOutputBytes: 10}
+  for _, name := range []string{"set.html", "macro.html", "center.html", "indent.html"} {
+    if err := renderLimited(t, env, name, nil); !IsLimitExceeded(err) {
+      t.Errorf("expected a limit to be exceeded rendering '%s', got: %v", name, err)
+    }
+  }
+  env.Limits = Limits{MaxOutputBytes: 14}
+  for _, name := range []string{"set.html", "macro.html"} {
+    if err := renderLimited(t, env, name, nil); err != nil {
+      t.Errorf("error rendering '%s' within its limits: %v", name, err)
+    }
+  }
+}
@@ -0,0 +1,178 @@
+package jinja2
+
+import (
+  "errors"
+  "io/fs"
+  "os"
+  "path"
+  "path/filepath"
+  "strings"
+)
+
+//-------------------------------------------------------------------------------------------------
+// A Loader is responsible for finding the source of a template by
+// name. GetSource returns the template source along with the name of
+// the file it came from (which may be empty if the loader does not
+// load from files). If the template cannot be found, the error must be
+// a *TemplateNotFoundError so that loaders like the ChoiceLoader can
+// move on to the next loader.
+type Loader interface {
+  GetSource(name string) (string, string, error)
+}
+
+type TemplateNotFoundError struct {
+  Name string
+}
+func (self *TemplateNotFoundError) Error() string {
+  return "the template '" + self.Name + "' was not found"
+}
+
+func IsTemplateNotFound(err error) bool {
+  var not_found *TemplateNotFoundError
+  return errors.As(err, &not_found)
+}
+
+// SplitTemplatePath splits a template name on '/' into its path
+// pieces, refusing any name which would escape the search path.
+func SplitTemplatePath(name string) ([]string, error) {
+  pieces := make([]string, 0)
+  for _, piece := range strings.Split(name, "/") {
+    if piece == ".." || strings.ContainsRune(piece, filepath.Separator) {
+      return nil, &TemplateNotFoundError{name}
+    } else if piece != "" && piece != "." {
+      pieces = append(pieces, piece)
+    }
+  }
+  if len(pieces) == 0 {
+    return nil, &TemplateNotFoundError{name}
+  }
+  return pieces, nil
+}
+
+//-------------------------------------------------------------------------------------------------
+// FileSystemLoader loads templates from one or more directories on
+// disk. The directories are searched in order and the first match wins.
+type FileSystemLoader struct {
+  SearchPath []string
+}
+func NewFileSystemLoader(search_path ...string) *FileSystemLoader {
+  return &FileSystemLoader{SearchPath: search_path}
+}
+func (self *FileSystemLoader) GetSource(name string) (string, string, error) {
+  pieces, err := SplitTemplatePath(name)
+  if err != nil {
+    return "", "", err
+  }
+  for _, search_path := range self.SearchPath {
+    filename := filepath.Join(append([]string{search_path}, pieces...)...)
+    data, err := os.ReadFile(filename)
+    if err != nil {
+      if os.IsNotExist(err) {
+        continue
+      }
+      return "", "", err
+    }
+    return string(data), filename, nil
+  }
+  return "", "", &TemplateNotFoundError{name}
+}
+
+//-------------------------------------------------------------------------------------------------
+// FSLoader loads templates from an fs.FS, which makes it possible to
+// ship templates inside the binary with go:embed.
+type FSLoader struct {
+  FS fs.FS
+}
+func NewFSLoader(fsys fs.FS) *FSLoader {
+  return &FSLoader{FS: fsys}
+}
+func (self *FSLoader) GetSource(name string) (string, string, error) {
+  pieces, err := SplitTemplatePath(name)
+  if err != nil {
+    return "", "", err
+  }
+  filename := path.Join(pieces...)
+  if !fs.ValidPath(filename) {
+    return "", "", &TemplateNotFoundError{name}
+  }
+  data, err := fs.ReadFile(self.FS, filename)
+  if err != nil {
+    if errors.Is(err, fs.ErrNotExist) {
+      return "", "", &TemplateNotFoundError{name}
+    }
+    return "", "", err
+  }
+  return string(data), filename, nil
+}
+
+//-------------------------------------------------------------------------------------------------
+// DictLoader loads templates from a map of template names to sources,
+// which is mostly useful for tests and small embedded templates.
+type DictLoader struct {
+  Mapping map[string]string
+}
+func NewDictLoader(mapping map[string]string) *DictLoader {
+  return &DictLoader{Mapping: mapping}
+}
+func (self *DictLoader) GetSource(name string) (string, string, error) {
+  if source, ok := self.Mapping[name]; ok {
+    return source, "", nil
+  }
+  return "", "", &TemplateNotFoundError{name}
+}
+
+//-------------------------------------------------------------------------------------------------
+// PrefixLoader dispatches to one of several loaders based on the
+// prefix of the template name, so "app/index.html" is looked up as
+// "index.html" in the loader registered for "app".
+type PrefixLoader struct {
+  Mapping map[string]Loader
+  Delimiter string
+}
+func NewPrefixLoader(mapping map[string]Loader) *PrefixLoader {
+  return &PrefixLoader{Mapping: mapping, Delimiter: "/"}
+}
+func (self *PrefixLoader) GetSource(name string) (string, string, error) {
+  delimiter := self.Delimiter
+  if delimiter == "" {
+    delimiter = "/"
+  }
+  parts := strings.SplitN(name, delimiter, 2)
+  if len(parts) != 2 {
+    return "", "", &TemplateNotFoundError{name}
+  }
+  loader, ok := self.Mapping[parts[0]]
+  if !ok {
+    return "", "", &TemplateNotFoundError{name}
+  }
+  source, filename, err := loader.GetSource(parts[1])
+  if err != nil {
+    if IsTemplateNotFound(err) {
+      // report the name that was asked for, not the stripped one
+      return "", "", &TemplateNotFoundError{name}
+    }
+    return "", "", err
+  }
+  return source, filename, nil
+}
+
+//-------------------------------------------------------------------------------------------------
+// ChoiceLoader tries each of its loaders in order, returning the first
+// template found.
+type ChoiceLoader struct {
+  Loaders []Loader
+}
+func NewChoiceLoader(loaders ...Loader) *ChoiceLoader {
+  return &ChoiceLoader{Loaders: loaders}
+}
+func (self *ChoiceLoader) GetSource(name string) (string, string, error) {
+  for _, loader := range self.Loaders {
+    source, filename, err := loader.GetSource(name)
+    if err == nil {
+      return source, filename, nil
+    } else if !IsTemplateNotFound(err) {
+      return "", "", err
+    }
+  }
+  return "", "", &TemplateNotFoundError{name}
+}
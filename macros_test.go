@@ -0,0 +1,101 @@
+package jinja2
+
+import (
+  "strings"
+  "testing"
+)
+
+func renderString(t *testing.T, source string, vars map[string]interface{}) string {
+  template := new(Template)
+  if err := template.Parse(source); err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  res, err := template.Render(NewContext(vars))
+  if err != nil {
+    t.Fatalf("error rendering template: %v", err)
+  }
+  return res
+}
+
+func TestMacro(t *testing.T) {
+  source := `{% macro input(name, value="", type="text") %}<input type={{ type }} name={{ name }} value={{ value }}>{% endmacro %}` +
+    `{{ input("user") }}{{ input("pass", type="password") }}`
+  expected := `<input type=text name=user value=><input type=password name=pass value=>`
+  if res := renderString(t, source, nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestMacroDefaultsUseEarlierArgs(t *testing.T) {
+  source := `{% macro add(a, b=a) %}{{ a + b }}{% endmacro %}{{ add(2) }} {{ add(2, 3) }}`
+  expected := "4 5"
+  if res := renderString(t, source, nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestMacroVarargsKwargs(t *testing.T) {
+  source := `{% macro m(a) %}{{ a }}{{ varargs }}{{ kwargs }}{% endmacro %}{{ m(1, 2, 3, x=4) }}`
+  expected := "1[2, 3]{'x': 4}"
+  if res := renderString(t, source, nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestMacroRecursion(t *testing.T) {
+  source := `{% macro countdown(n) %}{{ n }}{% if n > 0 %}{{ countdown(n - 1) }}{% endif %}{% endmacro %}{{ countdown(3) }}`
+  expected := "3210"
+  if res := renderString(t, source, nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestCallBlock(t *testing.T) {
+  source := `{% macro wrap(tag) %}<{{ tag }}>{{ caller() }}</{{ tag }}>{% endmacro %}` +
+    `{% call wrap("p") %}hello {{ name }}{% endcall %}`
+  expected := "<p>hello world</p>"
+  if res := renderString(t, source, map[string]interface{}{"name": "world"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestCallBlockWithArgs(t *testing.T) {
+  source := `{% macro each(items) %}{% for item in items %}{{ caller(item, pos=item + item) }}{% endfor %}{% endmacro %}` +
+    `{% call(value, pos) each(seq) %}{{ pos }}={{ value }};{% endcall %}`
+  expected := "aa=a;bb=b;"
+  if res := renderString(t, source, map[string]interface{}{"seq": []interface{}{"a", "b"}}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestMacroRejectsUnexpectedArgs(t *testing.T) {
+  tests := []struct {
+    source string
+    expected string
+  }{
+    {`{% macro m(a) %}{{ a }}{% endmacro %}{{ m(1, 2) }}`, "macro 'm' takes not more than 1 argument(s)"},
+    {`{% macro m(a) %}{{ a }}{% endmacro %}{{ m(1, b=2) }}`, "macro 'm' takes no keyword argument 'b'"},
+    {`{% macro m(a) %}{{ a }}{{ varargs }}{% endmacro %}{{ m(1, b=2) }}`, "macro 'm' takes no keyword argument 'b'"},
+    {`{% macro m(a) %}{{ a }}{{ kwargs }}{% endmacro %}{{ m(1, 2) }}`, "macro 'm' takes not more than 1 argument(s)"},
+    {`{% macro m(a) %}{{ a }}{% endmacro %}{% call m(1) %}body{% endcall %}`, "macro 'm' takes no keyword argument 'caller'"},
+  }
+  for _, test := range tests {
+    template := new(Template)
+    if err := template.Parse(test.source); err != nil {
+      t.Fatalf("error parsing template: %v", err)
+    }
+    _, err := template.Render(NewContext(nil))
+    if err == nil || !strings.Contains(err.Error(), test.expected) {
+      t.Errorf("Expected an error containing '%s' rendering '%s' but got: %v", test.expected, test.source, err)
+    }
+  }
+}
+
+func TestMacroTakesExtraArgsWhenUsed(t *testing.T) {
+  source := `{% macro m(a) %}{{ a }}{% if true %}{{ varargs|length }}{% endif %}{% set k = kwargs %}{{ k|length }}{% endmacro %}` +
+    `{{ m(1, 2, 3, x=4) }}`
+  expected := "121"
+  if res := renderString(t, source, nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
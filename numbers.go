@@ -0,0 +1,297 @@
+package jinja2
+
+import (
+  "errors"
+  "math"
+  "strconv"
+  "strings"
+)
+
+//-------------------------------------------------------------------------------------------------
+// The numeric filters convert values in the same way as the python
+// int() and float() builtins used by the Jinja2 filters, falling back
+// to the default when a value can't be converted. Python's integers
+// have no limit, but here they are int64s, so converting a number
+// outside of their range is an error.
+
+// floatToString formats a float the way python's repr does, so that
+// whole numbers keep their ".0" and very large or small numbers use an
+// exponent.
+func floatToString(f float64) string {
+  switch {
+  case math.IsInf(f, 1):
+    return "inf"
+  case math.IsInf(f, -1):
+    return "-inf"
+  case math.IsNaN(f):
+    return "nan"
+  }
+  exp := 0
+  if f != 0 {
+    exp = int(math.Floor(math.Log10(math.Abs(f))))
+  }
+  if exp < -4 || exp >= 16 {
+    return strconv.FormatFloat(f, 'e', -1, 64)
+  }
+  s := strconv.FormatFloat(f, 'f', -1, 64)
+  if !strings.Contains(s, ".") {
+    s += ".0"
+  }
+  return s
+}
+
+// parseInt parses an integer from a string in the base, in the same way
+// as python's int(). A prefix for the base (as in "0x") is allowed, and
+// a base of 0 takes the base from the prefix.
+func parseInt(s string, base int) (int64, error) {
+  s = strings.TrimSpace(s)
+  sign := ""
+  if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+    sign, s = s[:1], s[1:]
+  }
+  for _, prefix := range []struct{ text string; base int }{{"0x", 16}, {"0o", 8}, {"0b", 2}} {
+    if strings.HasPrefix(strings.ToLower(s), prefix.text) && (base == prefix.base || base == 0) {
+      s, base = s[2:], prefix.base
+    }
+  }
+  if base == 0 {
+    base = 10
+  }
+  // underscores may separate digits, but can't start or end the number
+  if strings.HasPrefix(s, "_") || strings.HasSuffix(s, "_") || strings.Contains(s, "__") || strings.ContainsAny(s, "+-") {
+    return 0, errors.New("invalid literal for int() with base " + strconv.Itoa(base) + ": '" + s + "'")
+  }
+  return strconv.ParseInt(sign + strings.ReplaceAll(s, "_", ""), base, 64)
+}
+
+// parseFloat parses a float from a string, in the same way as python's
+// float().
+func parseFloat(s string) (float64, error) {
+  s = strings.TrimSpace(s)
+  lower := strings.ToLower(strings.TrimLeft(s, "+-"))
+  if strings.HasPrefix(lower, "0x") || strings.HasPrefix(lower, "_") || strings.Contains(lower, "__") {
+    return 0, errors.New("could not convert string to float: '" + s + "'")
+  }
+  return strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), 64)
+}
+
+// toInt converts a value to an integer like the int filter, returning
+// false when it can't be converted. Numbers too big for an int64 are
+// an error, rather than something which can't be converted, as are
+// strict undefined values.
+func toInt(v VariableType, base int) (int64, bool, error) {
+  switch v.Type {
+  case PY_TYPE_UNDEFINED:
+    if u := AsUndefined(v); u.Policy == UNDEFINED_STRICT {
+      return 0, false, u.Error()
+    }
+  case PY_TYPE_INT, PY_TYPE_BOOL:
+    n, err := v.AsInt()
+    return n, err == nil, nil
+  case PY_TYPE_FLOAT:
+    f, _ := v.AsFloat()
+    return floatToIntChecked(f)
+  case PY_TYPE_STRING, PY_TYPE_MARKUP:
+    s, _ := v.AsString()
+    n, err := parseInt(s, base)
+    if err == nil {
+      return n, true, nil
+    } else if errors.Is(err, strconv.ErrRange) {
+      return 0, false, errors.New("integer out of range: '" + strings.TrimSpace(s) + "'")
+    }
+    // strings holding floats are truncated, as in "42.23"|int
+    if f, err := parseFloat(s); err == nil {
+      return floatToIntChecked(f)
+    }
+  }
+  return 0, false, nil
+}
+
+// floatToIntChecked truncates a float for toInt, where NaN can't be
+// converted and anything outside the range of an int64 is an error.
+func floatToIntChecked(f float64) (int64, bool, error) {
+  if math.IsNaN(f) {
+    return 0, false, nil
+  }
+  if n, ok := floatToInt(f); ok {
+    return n, true, nil
+  }
+  return 0, false, errors.New("integer out of range: '" + floatToString(f) + "'")
+}
+
+func floatToInt(f float64) (int64, bool) {
+  if math.IsNaN(f) || f >= math.MaxInt64 || f < math.MinInt64 {
+    return 0, false
+  }
+  return int64(f), true
+}
+
+// toFloat converts a value to a float like the float filter, returning
+// false when it can't be converted. Strict undefined values are an
+// error.
+func toFloat(v VariableType) (float64, bool, error) {
+  switch v.Type {
+  case PY_TYPE_UNDEFINED:
+    if u := AsUndefined(v); u.Policy == UNDEFINED_STRICT {
+      return 0, false, u.Error()
+    }
+  case PY_TYPE_INT, PY_TYPE_FLOAT, PY_TYPE_BOOL:
+    f, err := v.AsFloat()
+    return f, err == nil, nil
+  case PY_TYPE_STRING, PY_TYPE_MARKUP:
+    s, _ := v.AsString()
+    f, err := parseFloat(s)
+    return f, err == nil, nil
+  }
+  return 0, false, nil
+}
+
+// roundFloat rounds to the number of decimal places as python's round()
+// does, which rounds halfway values to even on the exact binary value.
+func roundFloat(f float64, precision int64) float64 {
+  if math.IsInf(f, 0) || math.IsNaN(f) {
+    return f
+  }
+  if precision < 0 {
+    scale := math.Pow(10, float64(-precision))
+    return math.RoundToEven(f / scale) * scale
+  }
+  res, err := strconv.ParseFloat(strconv.FormatFloat(f, 'f', int(precision), 64), 64)
+  if err != nil {
+    return f
+  }
+  return res
+}
+
+func addNumericFilters(filters map[string]PyCallable) {
+  filters["int"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      base, err := args[2].AsInt()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      n, ok, err := toInt(args[0], int(base))
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      } else if ok {
+        return VariableType{PY_TYPE_INT, n}, nil
+      }
+      return args[1], nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"default", VariableType{PY_TYPE_INT, int64(0)},},
+      {"base", VariableType{PY_TYPE_INT, int64(10)},},
+    },
+  }
+  filters["float"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      f, ok, err := toFloat(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      } else if ok {
+        return VariableType{PY_TYPE_FLOAT, f}, nil
+      }
+      return args[1], nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"default", VariableType{PY_TYPE_FLOAT, float64(0.0)},},
+    },
+  }
+  filters["round"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      method, err := VariableResToString(args[2])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      if method != "common" && method != "ceil" && method != "floor" {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("method must be common, ceil or floor")
+      }
+      precision, err := args[1].AsInt()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      value := args[0]
+      if value.Type != PY_TYPE_INT && value.Type != PY_TYPE_FLOAT && value.Type != PY_TYPE_BOOL {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("type " + PyTypeToString(value.Type) + " doesn't define __round__ method")
+      }
+      f, _ := value.AsFloat()
+      if method == "common" {
+        // as with python's round(), rounding an integer gives an integer
+        if value.Type != PY_TYPE_FLOAT {
+          n, _ := value.AsInt()
+          if precision < 0 {
+            n = int64(roundFloat(float64(n), precision))
+          }
+          return VariableType{PY_TYPE_INT, n}, nil
+        }
+        return VariableType{PY_TYPE_FLOAT, roundFloat(f, precision)}, nil
+      }
+      scale := math.Pow(10, float64(precision))
+      if method == "ceil" {
+        return VariableType{PY_TYPE_FLOAT, math.Ceil(f * scale) / scale}, nil
+      }
+      return VariableType{PY_TYPE_FLOAT, math.Floor(f * scale) / scale}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"precision", VariableType{PY_TYPE_INT, int64(0)},},
+      {"method", VariableType{PY_TYPE_STRING, "common"},},
+    },
+  }
+  filters["abs"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      switch args[0].Type {
+      case PY_TYPE_INT, PY_TYPE_BOOL:
+        n, _ := args[0].AsInt()
+        if n < 0 {
+          n = -n
+        }
+        return VariableType{PY_TYPE_INT, n}, nil
+      case PY_TYPE_FLOAT:
+        f, _ := args[0].AsFloat()
+        return VariableType{PY_TYPE_FLOAT, math.Abs(f)}, nil
+      }
+      return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("bad operand type for abs(): '" + PyTypeToString(args[0].Type) + "'")
+    }, []CallableArg {
+      {"x", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  filters["filesizeformat"] = PyCallable {
+    func(args []VariableType) (VariableType, error) {
+      bytes, ok, err := toFloat(args[0])
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      } else if !ok {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, errors.New("could not convert '" + PyTypeToString(args[0].Type) + "' to a file size")
+      }
+      binary, err := args[1].AsBool()
+      if err != nil {
+        return VariableType{PY_TYPE_UNDEFINED, nil}, err
+      }
+      base := 1000.0
+      prefixes := []string{"kB", "MB", "GB", "TB", "PB", "EB", "ZB", "YB"}
+      if binary {
+        base = 1024.0
+        prefixes = []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB", "ZiB", "YiB"}
+      }
+      if bytes == 1 {
+        return VariableType{PY_TYPE_STRING, "1 Byte"}, nil
+      } else if bytes < base {
+        n, _ := floatToInt(bytes)
+        return VariableType{PY_TYPE_STRING, strconv.FormatInt(n, 10) + " Bytes"}, nil
+      }
+      // the largest prefix is used for anything bigger than it
+      unit := base
+      prefix := ""
+      for _, prefix = range prefixes {
+        unit *= base
+        if bytes < unit {
+          break
+        }
+      }
+      return VariableType{PY_TYPE_STRING, strconv.FormatFloat(base * bytes / unit, 'f', 1, 64) + " " + prefix}, nil
+    }, []CallableArg {
+      {"value", VariableType{PY_TYPE_UNDEFINED, nil},},
+      {"binary", VariableType{PY_TYPE_BOOL, false},},
+    },
+  }
+}
@@ -0,0 +1,91 @@
+package jinja2
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestIntFilter(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), nil, map[string]string{
+    `{{ "42"|int }}|{{ "ajsghasjgd"|int }}|{{ "32.32"|int }}`: "42|0|32",
+    `{{ "0x4d32"|int(0, 16) }}|{{ "011"|int(0, 8) }}|{{ "0x33FU"|int(0, 16) }}`: "19762|9|0",
+    `{{ "0b101"|int(base=2) }}|{{ "0o17"|int(base=0) }}|{{ "ff"|int(base=16) }}`: "5|15|255",
+    `{{ "12345678901"|int }}|{{ " -7 "|int }}|{{ "1_000"|int }}`: "12345678901|-7|1000",
+    `{{ 4.7|int }}|{{ -4.7|int }}|{{ true|int }}|{{ 3|int }}`: "4|-4|1|3",
+    `{{ "x"|int(-1) }}|{{ "x"|int(default="none") }}|{{ [1]|int }}|{{ missing|int }}`: "-1|none|0|0",
+    `{{ "1__0"|int }}|{{ "_1"|int }}|{{ "1e3"|int }}`: "0|0|1000",
+    `{{ "9223372036854775807"|int }}|{{ "-9223372036854775808"|int }}|{{ "nan"|int }}`: "9223372036854775807|-9223372036854775808|0",
+  })
+  for source, message := range map[string]string{
+    `{{ '9223372036854775808'|int }}`: "integer out of range: '9223372036854775808'",
+    `{{ '-0x8000000000000001'|int(base=16) }}`: "integer out of range: '-0x8000000000000001'",
+    `{{ '1e30'|int }}`: "integer out of range: '1e+30'",
+    `{{ 'inf'|int }}`: "integer out of range: 'inf'",
+  } {
+    err := renderUndefinedError(t, NewEnvironment(nil), source)
+    if err == nil || !strings.Contains(err.Error(), message) {
+      t.Errorf("expected the error '%s' rendering '%s', got: %v", message, source, err)
+    }
+  }
+}
+
+func TestFloatFilter(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), nil, map[string]string{
+    `{{ "42"|float }}|{{ "ajsghasjgd"|float }}|{{ "32.32"|float }}`: "42.0|0.0|32.32",
+    `{{ 3|float }}|{{ true|float }}|{{ " 1e3 "|float }}|{{ "-inf"|float }}`: "3.0|1.0|1000.0|-inf",
+    `{{ "x"|float(default=1.5) }}|{{ "x"|float("n/a") }}|{{ "0x10"|float }}`: "1.5|n/a|0.0",
+    `{{ 1.0 }}|{{ 0.00001 }}|{{ 12345678901234567.0 }}|{{ 0.1 }}`: "1.0|1e-05|1.2345678901234568e+16|0.1",
+  })
+}
+
+func TestRoundFilter(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), nil, map[string]string{
+    `{{ 2.7|round }}|{{ 2.1|round }}|{{ 2.1234|round(3, 'floor') }}|{{ 2.1|round(0, 'ceil') }}`: "3.0|2.0|2.123|3.0",
+    `{{ 21.3|round(-1) }}|{{ 21.3|round(-1, 'ceil') }}|{{ 21.3|round(-1, 'floor') }}`: "20.0|30.0|20.0",
+    `{{ 42.55|round }}|{{ 42.55|round(1, 'floor') }}|{{ 42.55|round(1) }}`: "43.0|42.5|42.5",
+    `{{ 2.5|round }}|{{ 3.5|round }}|{{ -2.5|round }}`: "2.0|4.0|-2.0",
+    `{{ 42|round }}|{{ 1250|round(-2) }}|{{ 42|round(0, 'floor') }}|{{ true|round }}`: "42|1200|42.0|1",
+  })
+  for _, source := range []string{`{{ 1.5|round(method="up") }}`, `{{ "1.5"|round }}`} {
+    if err := renderUndefinedError(t, NewEnvironment(nil), source); err == nil {
+      t.Errorf("expected an error rendering '%s'", source)
+    }
+  }
+}
+
+func TestAbsFilter(t *testing.T) {
+  vars := map[string]interface{}{"n": -1, "f": -3.14}
+  checkFilterResults(t, NewEnvironment(nil), vars, map[string]string{
+    `{{ n|abs }}|{{ 1|abs }}|{{ f|abs }}|{{ true|abs }}`: "1|1|3.14|1",
+  })
+  if err := renderUndefinedError(t, NewEnvironment(nil), `{{ "a"|abs }}`); err == nil {
+    t.Errorf("expected an error taking the abs of a string")
+  }
+}
+
+func TestFilesizeformatFilter(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), nil, map[string]string{
+    `{{ 100|filesizeformat }}|{{ 1000|filesizeformat }}|{{ 1000000|filesizeformat }}|{{ 1000000000|filesizeformat }}|{{ 1000000000000|filesizeformat }}`: "100 Bytes|1.0 kB|1.0 MB|1.0 GB|1.0 TB",
+    `{{ 100|filesizeformat(true) }}|{{ 1000|filesizeformat(true) }}|{{ 1000000|filesizeformat(true) }}|{{ 1000000000|filesizeformat(true) }}|{{ 1000000000000|filesizeformat(true) }}`: "100 Bytes|1000 Bytes|976.6 KiB|953.7 MiB|931.3 GiB",
+    `{{ 300|filesizeformat }}|{{ 3000|filesizeformat }}|{{ 3000000|filesizeformat }}|{{ 3000|filesizeformat(true) }}|{{ 3000000|filesizeformat(true) }}`: "300 Bytes|3.0 kB|3.0 MB|2.9 KiB|2.9 MiB",
+    `{{ 1|filesizeformat }}|{{ 0|filesizeformat }}|{{ "1000"|filesizeformat }}|{{ 1.0e30|filesizeformat }}`: "1 Byte|0 Bytes|1.0 kB|1000000.0 YB",
+  })
+  if err := renderUndefinedError(t, NewEnvironment(nil), `{{ "big"|filesizeformat }}`); err == nil {
+    t.Errorf("expected an error formatting a string which isn't a number")
+  }
+}
+
+func TestNumericFiltersStrictUndefined(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.Undefined = UNDEFINED_STRICT
+  for _, source := range []string{`{{ missing|int }}`, `{{ missing|int(5) }}`, `{{ missing|float }}`, `{{ missing|filesizeformat }}`} {
+    if err := renderUndefinedError(t, env, source); !IsUndefinedError(err) {
+      t.Errorf("expected an undefined error rendering '%s', got: %v", source, err)
+    }
+  }
+  // the default is only for values which aren't strict
+  env.Undefined = UNDEFINED_LENIENT
+  checkFilterResults(t, env, nil, map[string]string{
+    `{{ missing|int(5) }}|{{ missing|float(1.5) }}`: "5|1.5",
+  })
+}
@@ -0,0 +1,77 @@
+package jinja2
+
+import (
+  "reflect"
+  "sync"
+  "github.com/alecthomas/participle"
+)
+
+// The most statements a parser remembers before it starts over, which
+// keeps the memory used by the cache bounded for long running
+// programs which parse many different templates.
+const statement_cache_size = 4096
+
+// A statementParser parses one kind of statement found inside of a
+// tag. Building the participle parser reflects over the whole grammar,
+// so it is done once for each kind of statement, and the statements
+// parsed are memoized by their source since templates tend to repeat
+// the same expressions. The parsed statements are never modified
+// while rendering, so the same one can safely be shared.
+type statementParser struct {
+  parser *participle.Parser
+  grammar reflect.Type
+  mutex sync.Mutex
+  cache map[string]interface{}
+}
+
+func newStatementParser(grammar interface{}) *statementParser {
+  parser, err := participle.Build(grammar, PythonLexer)
+  if err != nil {
+    panic(err)
+  }
+  p := new(statementParser)
+  p.parser = parser
+  p.grammar = reflect.TypeOf(grammar).Elem()
+  p.cache = make(map[string]interface{})
+  return p
+}
+
+// parse returns the statement for the source, which is a pointer to a
+// new value of the grammar type. Errors are not cached.
+func (self *statementParser) parse(statement string) (interface{}, error) {
+  self.mutex.Lock()
+  ast, ok := self.cache[statement]
+  self.mutex.Unlock()
+  if ok {
+    return ast, nil
+  }
+  ast = reflect.New(self.grammar).Interface()
+  if err := self.parser.ParseString(statement, ast); err != nil {
+    return nil, err
+  }
+  self.mutex.Lock()
+  if len(self.cache) >= statement_cache_size {
+    self.cache = make(map[string]interface{})
+  }
+  self.cache[statement] = ast
+  self.mutex.Unlock()
+  return ast, nil
+}
+
+var (
+  variable_parser    = newStatementParser(&VariableStatement{})
+  if_parser          = newStatementParser(&IfStatement{})
+  elif_parser        = newStatementParser(&ElifStatement{})
+  for_parser         = newStatementParser(&ForStatement{})
+  extends_parser     = newStatementParser(&ExtendsStatement{})
+  block_parser       = newStatementParser(&BlockStatement{})
+  include_parser     = newStatementParser(&IncludeStatement{})
+  macro_parser       = newStatementParser(&MacroStatement{})
+  call_parser        = newStatementParser(&CallStatement{})
+  import_parser      = newStatementParser(&ImportStatement{})
+  from_import_parser = newStatementParser(&FromImportStatement{})
+  set_parser         = newStatementParser(&SetStatement{})
+  with_parser        = newStatementParser(&WithStatement{})
+  filter_parser      = newStatementParser(&FilterStatement{})
+  autoescape_parser  = newStatementParser(&AutoescapeStatement{})
+)
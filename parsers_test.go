@@ -0,0 +1,71 @@
+package jinja2
+
+import (
+  "strings"
+  "testing"
+  "github.com/alecthomas/participle"
+)
+
+func TestStatementCache(t *testing.T) {
+  first, err := ParseVariableStatement("user.name|upper")
+  if err != nil {
+    t.Fatalf("error parsing statement: %v", err)
+  }
+  second, err := ParseVariableStatement("user.name|upper")
+  if err != nil {
+    t.Fatalf("error parsing statement: %v", err)
+  }
+  if first != second {
+    t.Errorf("expected identical statements to share the parsed statement")
+  }
+  if _, err := ParseVariableStatement("user.name|"); err == nil {
+    t.Errorf("expected an error parsing an invalid statement")
+  }
+  if _, err := ParseVariableStatement("user.name|"); err == nil {
+    t.Errorf("expected an error parsing an invalid statement a second time")
+  }
+}
+
+const benchTemplate = `{% for user in users if user.active %}
+  <li class="{{ loop.index|int }}">{{ user.name|upper }} ({{ user.email|replace('@', ' at ') }})</li>
+  {% if user.admin %}admin{% elif user.staff %}staff{% else %}user{% endif %}
+{% endfor %}
+{% set total = users|length %}
+`
+
+// BenchmarkBuildParser is what parsing every tag cost before the
+// parsers were built once.
+func BenchmarkBuildParser(b *testing.B) {
+  for i := 0; i < b.N; i++ {
+    if _, err := participle.Build(&VariableStatement{}, PythonLexer); err != nil {
+      b.Fatal(err)
+    }
+  }
+}
+
+func BenchmarkParseStatementUncached(b *testing.B) {
+  for i := 0; i < b.N; i++ {
+    ast := &VariableStatement{}
+    if err := variable_parser.parser.ParseString("user.email|replace('@', ' at ')", ast); err != nil {
+      b.Fatal(err)
+    }
+  }
+}
+
+func BenchmarkParseStatement(b *testing.B) {
+  for i := 0; i < b.N; i++ {
+    if _, err := ParseVariableStatement("user.email|replace('@', ' at ')"); err != nil {
+      b.Fatal(err)
+    }
+  }
+}
+
+func BenchmarkParseTemplate(b *testing.B) {
+  source := `{% extends "base.html" %}{% block body %}` + strings.Repeat(benchTemplate, 20) + `{% endblock %}`
+  for i := 0; i < b.N; i++ {
+    template := new(Template)
+    if err := template.Parse(source); err != nil {
+      b.Fatal(err)
+    }
+  }
+}
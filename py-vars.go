@@ -16,6 +16,12 @@ const (
   PY_TYPE_TUPLE     PyType = 7
   PY_TYPE_DICT      PyType = 8
   PY_TYPE_IDENT     PyType = 10
+  PY_TYPE_CALLABLE  PyType = 11
+  PY_TYPE_MODULE    PyType = 12
+  PY_TYPE_MARKUP    PyType = 13
+  // a callable arg with this type is given the context the call is
+  // made from, and can't be passed by the template
+  PY_TYPE_CONTEXT   PyType = 14
 )
 
 func PyTypeToString(v PyType) string {
@@ -38,18 +44,57 @@ func PyTypeToString(v PyType) string {
     return "tuple"
   case PY_TYPE_DICT:
     return "dict"
+  case PY_TYPE_CALLABLE:
+    return "callable"
+  case PY_TYPE_MODULE:
+    return "module"
+  case PY_TYPE_MARKUP:
+    return "Markup"
+  case PY_TYPE_CONTEXT:
+    return "context"
   }
   return ""
 }
 
+// A TemplateModule is the result of importing a template, and holds
+// the macros and top-level variables exported by that template.
+type TemplateModule struct {
+  Name string
+  Exports map[string]VariableType
+}
+
+// A NamedTuple is a tuple whose items can also be looked up as
+// attributes by name, like a python namedtuple. It is the Data of a
+// PY_TYPE_TUPLE, in place of the usual list of items.
+type NamedTuple struct {
+  Names []string
+  Items []VariableType
+}
+
+// Markup is a string which is known to be safe to place in HTML, so
+// it is not escaped again when autoescaping is enabled.
+type Markup string
+
 type VariableType struct {
   Type PyType
   Data interface{}
 }
 func (self *VariableType) AsBool() (bool, error) {
   switch res := self.Type; res {
+  case PY_TYPE_UNDEFINED:
+    return AsUndefined(*self).Bool()
   case PY_TYPE_NONE:
     return false, nil
+  case PY_TYPE_LIST, PY_TYPE_TUPLE:
+    if v, err := self.AsList(); err == nil {
+      return len(v) > 0, nil
+    }
+  case PY_TYPE_DICT:
+    if v, ok := self.Data.(map[VariableType]VariableType); ok {
+      return len(v) > 0, nil
+    }
+  case PY_TYPE_CALLABLE, PY_TYPE_MODULE:
+    return true, nil
   case PY_TYPE_BOOL:
     return self.Data.(bool), nil
   case PY_TYPE_INT:
@@ -66,7 +111,7 @@ func (self *VariableType) AsBool() (bool, error) {
       }
     } else { // FIXME: error handling
     }
-  case PY_TYPE_STRING:
+  case PY_TYPE_STRING, PY_TYPE_MARKUP:
     if v, ok := self.Data.(string); ok {
       if v == "" { return false, nil
       } else { return true, nil
@@ -134,7 +179,7 @@ func (self *VariableType) AsFloat() (float64, error) {
 }
 func (self *VariableType) AsString() (string, error) {
   switch res := self.Type; res {
-  case PY_TYPE_STRING:
+  case PY_TYPE_STRING, PY_TYPE_MARKUP:
     if v, ok := self.Data.(string); ok {
       return v, nil
     } else {
@@ -144,3 +189,17 @@ func (self *VariableType) AsString() (string, error) {
     return "", errors.New("could not convert variable to a string")
   }
 }
+func (self *VariableType) AsList() ([]VariableType, error) {
+  switch res := self.Type; res {
+  case PY_TYPE_LIST, PY_TYPE_TUPLE:
+    if v, ok := self.Data.([]VariableType); ok {
+      return v, nil
+    } else if v, ok := self.Data.(NamedTuple); ok {
+      return v.Items, nil
+    } else {
+      return nil, errors.New("could not convert variable to a list")
+    }
+  default:
+    return nil, errors.New("could not convert variable to a list")
+  }
+}
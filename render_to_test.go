@@ -0,0 +1,69 @@
+package jinja2
+
+import (
+  "bytes"
+  "errors"
+  "testing"
+)
+
+type failingWriter struct {
+  written int
+  limit int
+}
+var errWriteFailed = errors.New("write failed")
+func (self *failingWriter) Write(p []byte) (int, error) {
+  if self.written + len(p) > self.limit {
+    return 0, errWriteFailed
+  }
+  self.written += len(p)
+  return len(p), nil
+}
+
+func TestRenderTo(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "base.html": "<h1>{% block title %}{% endblock %}</h1>{% include 'footer.html' %}",
+    "footer.html": "<p>{{ name }}</p>",
+    "page.html": "{% extends 'base.html' %}ignored{% block title %}{% for i in [1, 2] %}{{ i }}{% endfor %}{% endblock %}",
+  }))
+  template, err := env.GetTemplate("page.html")
+  if err != nil {
+    t.Fatalf("error loading template: %v", err)
+  }
+  var buf bytes.Buffer
+  if err := template.RenderTo(&buf, env.NewContext(map[string]interface{}{"name": "bob"})); err != nil {
+    t.Fatalf("error rendering template: %v", err)
+  }
+  expected := "<h1>12</h1><p>bob</p>"
+  if res := buf.String(); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestRenderToPartialOutput(t *testing.T) {
+  template := new(Template)
+  if err := template.Parse("before {{ 1 + 'a' }} after"); err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  var buf bytes.Buffer
+  if err := template.RenderTo(&buf, NewContext(nil)); err == nil {
+    t.Errorf("expected an error rendering the template")
+  }
+  expected := "before "
+  if res := buf.String(); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestRenderToWriteError(t *testing.T) {
+  template := new(Template)
+  if err := template.Parse("{% for i in [1, 2, 3] %}{{ i }}-{% endfor %}"); err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  w := &failingWriter{limit: 3}
+  if err := template.RenderTo(w, NewContext(nil)); !errors.Is(err, errWriteFailed) {
+    t.Errorf("expected the write error to be returned, got: %v", err)
+  }
+  if w.written != 3 {
+    t.Errorf("expected 3 bytes to be written before the error, got %d", w.written)
+  }
+}
@@ -0,0 +1,78 @@
+package jinja2
+
+import (
+  "errors"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+func renderError(t *testing.T, env *Environment, name string, vars map[string]interface{}) *TemplateRuntimeError {
+  template, err := env.GetTemplate(name)
+  if err != nil {
+    t.Fatalf("error loading template '%s': %v", name, err)
+  }
+  _, err = template.Render(env.NewContext(vars))
+  var runtime_err *TemplateRuntimeError
+  if !errors.As(err, &runtime_err) {
+    t.Fatalf("expected a runtime error rendering '%s', got: %v", name, err)
+  }
+  return runtime_err
+}
+
+func TestRuntimeErrorStack(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "macros.html": "{% macro card(user) %}\n<b>{{ user|nope }}</b>\n{% endmacro %}",
+    "inner.html": "{% import 'macros.html' as m %}\nline\n{{ m.card(user) }}",
+    "page.html": "start\n{% include 'inner.html' %}",
+  }))
+  err := renderError(t, env, "page.html", map[string]interface{}{"user": "bob"})
+  if err.Name != "macros.html" || err.Line != 2 || err.Column != 7 || err.Source != "user|nope" {
+    t.Errorf("wrong error location. Got: %s:%d:%d '%s'", err.Name, err.Line, err.Column, err.Source)
+  }
+  expected := []StackFrame{
+    {"macro 'card'", "inner.html", 3, 4},
+    {"include 'inner.html'", "page.html", 2, 4},
+  }
+  if !reflect.DeepEqual(err.Stack, expected) {
+    t.Errorf("wrong error stack. Got: %v but expected %v", err.Stack, expected)
+  }
+  if !strings.Contains(err.Error(), "in macro 'card' at inner.html:3:4") {
+    t.Errorf("the stack is missing from the error message: %s", err.Error())
+  }
+}
+
+func TestRuntimeErrorBlocks(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "base.html": "<html>\n{% block body %}{% endblock %}</html>",
+    "child.html": "{% extends 'base.html' %}\n{% block body %}\n  {{ 1 + 'a' }}\n{% endblock %}",
+  }))
+  err := renderError(t, env, "child.html", nil)
+  if err.Name != "child.html" || err.Line != 3 || err.Column != 6 {
+    t.Errorf("wrong error location. Got: %s:%d:%d", err.Name, err.Line, err.Column)
+  }
+  if len(err.Stack) != 1 || err.Stack[0].Description != "block 'body'" || err.Stack[0].Name != "base.html" {
+    t.Errorf("wrong error stack: %v", err.Stack)
+  }
+}
+
+func TestRuntimeErrorsAreNotSwallowed(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "elif.html": "{% if false %}a\n{% elif x|nope %}b{% endif %}",
+    "for.html": "{% for a, b in [1, 2] %}{% endfor %}",
+  }))
+  err := renderError(t, env, "elif.html", map[string]interface{}{"x": 1})
+  if err.Line != 2 || err.Source != "elif x|nope" {
+    t.Errorf("wrong error location. Got: %d:%d '%s'", err.Line, err.Column, err.Source)
+  }
+  renderError(t, env, "for.html", nil)
+}
+
+func TestIfTruthiness(t *testing.T) {
+  source := "{% if items %}items{% endif %}{% if empty %}empty{% else %}none{% endif %}{% if name %} {{ name }}{% endif %}"
+  expected := "itemsnone bob"
+  vars := map[string]interface{}{"items": []interface{}{1}, "empty": []interface{}{}, "name": "bob"}
+  if res := renderString(t, source, vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
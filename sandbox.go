@@ -0,0 +1,135 @@
+package jinja2
+
+import (
+  "errors"
+  "strings"
+)
+
+//-------------------------------------------------------------------------------------------------
+// A SandboxedEnvironment is an Environment for rendering templates
+// which aren't trusted. Every attribute looked up and every call,
+// filter and test used by its templates is checked with the
+// IsSafeAttribute and IsSafeCallable hooks, and anything they don't
+// allow fails with a SecurityError.
+//
+// The hooks default to DefaultIsSafeAttribute and DefaultIsSafeCallable,
+// which block names starting with an underscore and otherwise only
+// apply the allowlists. AllowedAttributes maps the name of a type (as
+// in "dict" or "module") to the attributes which may be looked up on
+// values of that type, and the allowlists for callables hold the names
+// of the filters, tests and calls which may be used. Calls are named by
+// the variable or attribute they are made on, and include macros as
+// well as caller() and super(). A nil allowlist allows everything.
+type SandboxedEnvironment struct {
+  *Environment
+  AllowedAttributes map[string][]string
+  AllowedFilters []string
+  AllowedTests []string
+  AllowedCalls []string
+  IsSafeAttribute func(sandbox *SandboxedEnvironment, owner VariableType, attr string, value VariableType) bool
+  IsSafeCallable func(sandbox *SandboxedEnvironment, kind CallableKind, name string) bool
+}
+
+// CallableKind says how a callable is being used by a template.
+type CallableKind int
+const (
+  CALLABLE_CALL   CallableKind = 0
+  CALLABLE_FILTER CallableKind = 1
+  CALLABLE_TEST   CallableKind = 2
+)
+
+func (self CallableKind) String() string {
+  switch self {
+  case CALLABLE_FILTER:
+    return "filter"
+  case CALLABLE_TEST:
+    return "test"
+  }
+  return "call"
+}
+
+// SecurityError is returned when a sandboxed template tries to use an
+// attribute or callable it isn't allowed to.
+type SecurityError struct {
+  Message string
+}
+func (self *SecurityError) Error() string {
+  return self.Message
+}
+
+func IsSecurityError(err error) bool {
+  var security_err *SecurityError
+  return errors.As(err, &security_err)
+}
+
+func NewSandboxedEnvironment(loader Loader) *SandboxedEnvironment {
+  sandbox := new(SandboxedEnvironment)
+  sandbox.Environment = NewEnvironment(loader)
+  sandbox.Environment.sandbox = sandbox
+  sandbox.IsSafeAttribute = DefaultIsSafeAttribute
+  sandbox.IsSafeCallable = DefaultIsSafeCallable
+  return sandbox
+}
+
+// DefaultIsSafeAttribute blocks attributes starting with an underscore,
+// and any attribute missing from the allowlist for the owner's type.
+func DefaultIsSafeAttribute(sandbox *SandboxedEnvironment, owner VariableType, attr string, value VariableType) bool {
+  if strings.HasPrefix(attr, "_") {
+    return false
+  }
+  if allowed, ok := sandbox.AllowedAttributes[PyTypeToString(owner.Type)]; ok {
+    return containsName(allowed, attr)
+  }
+  return true
+}
+
+// DefaultIsSafeCallable blocks callables starting with an underscore,
+// and any callable missing from the allowlist for its kind.
+func DefaultIsSafeCallable(sandbox *SandboxedEnvironment, kind CallableKind, name string) bool {
+  if strings.HasPrefix(name, "_") {
+    return false
+  }
+  allowed := sandbox.AllowedCalls
+  switch kind {
+  case CALLABLE_FILTER:
+    allowed = sandbox.AllowedFilters
+  case CALLABLE_TEST:
+    allowed = sandbox.AllowedTests
+  }
+  return allowed == nil || containsName(allowed, name)
+}
+
+func containsName(names []string, name string) bool {
+  for _, n := range names {
+    if n == name {
+      return true
+    }
+  }
+  return false
+}
+
+// checkAttribute returns a SecurityError when the template is sandboxed
+// and may not look up the attribute on the owner.
+func (self *Context) checkAttribute(owner VariableType, attr string, value VariableType) error {
+  if self.Env == nil || self.Env.sandbox == nil {
+    return nil
+  }
+  sandbox := self.Env.sandbox
+  if sandbox.IsSafeAttribute != nil && !sandbox.IsSafeAttribute(sandbox, owner, attr, value) {
+    return &SecurityError{"access to attribute '" + attr + "' of '" + PyTypeToString(owner.Type) + "' object is unsafe"}
+  }
+  return nil
+}
+
+// checkCallable returns a SecurityError when the template is sandboxed
+// and may not use the callable.
+func (self *Context) checkCallable(kind CallableKind, name string) error {
+  if self.Env == nil || self.Env.sandbox == nil {
+    return nil
+  }
+  sandbox := self.Env.sandbox
+  if sandbox.IsSafeCallable != nil && !sandbox.IsSafeCallable(sandbox, kind, name) {
+    return &SecurityError{"the " + kind.String() + " '" + name + "' is unsafe"}
+  }
+  return nil
+}
@@ -0,0 +1,95 @@
+package jinja2
+
+import (
+  "testing"
+)
+
+func renderSandboxed(t *testing.T, env *SandboxedEnvironment, source string, vars map[string]interface{}) (string, error) {
+  template, err := env.FromString(source)
+  if err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  return template.Render(env.NewContext(vars))
+}
+
+func TestSandboxUnderscoreAttributes(t *testing.T) {
+  env := NewSandboxedEnvironment(nil)
+  vars := map[string]interface{}{"user": map[interface{}]interface{}{"name": "bob", "_password": "secret"}}
+  if res, err := renderSandboxed(t, env, "{{ user.name|upper }}", vars); err != nil || res != "BOB" {
+    t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, "BOB")
+  }
+  if _, err := renderSandboxed(t, env, "{{ user._password }}", vars); !IsSecurityError(err) {
+    t.Errorf("expected a security error, got: %v", err)
+  }
+  // the same template is fine outside of the sandbox
+  if res := renderWithEnv(t, NewEnvironment(nil), "{{ user._password }}", vars); res != "secret" {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, "secret")
+  }
+}
+
+func TestSandboxAllowlists(t *testing.T) {
+  env := NewSandboxedEnvironment(nil)
+  env.AllowedAttributes = map[string][]string{"dict": []string{"name"}}
+  env.AllowedFilters = []string{"upper"}
+  env.AllowedTests = []string{}
+  env.AllowedCalls = []string{"greet"}
+  vars := map[string]interface{}{"user": map[interface{}]interface{}{"name": "bob", "email": "bob@example.com"}}
+  source := "{% macro greet(n) %}hi {{ n }}{% endmacro %}{{ greet(user.name|upper) }}"
+  if res, err := renderSandboxed(t, env, source, vars); err != nil || res != "hi BOB" {
+    t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, "hi BOB")
+  }
+  for _, source := range []string{
+    "{{ user.email }}",
+    "{{ user.name|replace('b', 'B') }}",
+    "{{ user is defined }}",
+    "{% macro other() %}{% endmacro %}{{ other() }}",
+  } {
+    if _, err := renderSandboxed(t, env, source, vars); !IsSecurityError(err) {
+      t.Errorf("expected a security error rendering '%s', got: %v", source, err)
+    }
+  }
+}
+
+func TestSandboxHooks(t *testing.T) {
+  env := NewSandboxedEnvironment(nil)
+  env.IsSafeAttribute = func(sandbox *SandboxedEnvironment, owner VariableType, attr string, value VariableType) bool {
+    return value.Type != PY_TYPE_STRING && DefaultIsSafeAttribute(sandbox, owner, attr, value)
+  }
+  env.IsSafeCallable = func(sandbox *SandboxedEnvironment, kind CallableKind, name string) bool {
+    return kind != CALLABLE_FILTER
+  }
+  vars := map[string]interface{}{"user": map[interface{}]interface{}{"name": "bob", "age": 7}}
+  if res, err := renderSandboxed(t, env, "{{ user.age }}", vars); err != nil || res != "7" {
+    t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, "7")
+  }
+  for _, source := range []string{"{{ user.name }}", "{{ user.age|int }}"} {
+    if _, err := renderSandboxed(t, env, source, vars); !IsSecurityError(err) {
+      t.Errorf("expected a security error rendering '%s', got: %v", source, err)
+    }
+  }
+}
+
+func TestSandboxWithForeignContext(t *testing.T) {
+  env := NewSandboxedEnvironment(NewDictLoader(map[string]string{
+    "secret.html": "{{ user._password }}",
+    "index.html": "{% include 'name.html' %}",
+    "name.html": "{{ user.name }}",
+  }))
+  vars := map[string]interface{}{"user": map[interface{}]interface{}{"name": "bob", "_password": "secret"}}
+  // a context from another environment doesn't get around the sandbox,
+  // and the template loads through its own environment
+  other := NewEnvironment(nil)
+  template, err := env.GetTemplate("secret.html")
+  if err != nil {
+    t.Fatalf("error loading template: %v", err)
+  }
+  if _, err := template.Render(other.NewContext(vars)); !IsSecurityError(err) {
+    t.Errorf("expected a security error, got: %v", err)
+  }
+  if template, err = env.GetTemplate("index.html"); err != nil {
+    t.Fatalf("error loading template: %v", err)
+  }
+  if res, err := template.Render(other.NewContext(vars)); err != nil || res != "bob" {
+    t.Errorf("Template result was incorrect. Got: '%s' (%v) but expected '%s'", res, err, "bob")
+  }
+}
@@ -0,0 +1,90 @@
+package jinja2
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestSet(t *testing.T) {
+  source := `{% set x = 1 + 2 %}{% set a, b = "a", "b" %}{{ x }}{{ a }}{{ b }}`
+  expected := "3ab"
+  if res := renderString(t, source, nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestSetUnpackErrors(t *testing.T) {
+  for _, source := range []string{`{% set a, b = 1 %}`, `{% set a, b = 1, 2, 3 %}`} {
+    template := new(Template)
+    if err := template.Parse(source); err != nil {
+      t.Fatalf("error parsing template: %v", err)
+    }
+    if _, err := template.Render(NewContext(nil)); err == nil {
+      t.Errorf("expected an assignment error rendering '%s'", source)
+    }
+  }
+}
+
+func TestBlockSet(t *testing.T) {
+  context := NewContext(map[string]interface{}{"name": "world"})
+  context.Filters["upper"] = PyCallable{
+    func(args []VariableType) (VariableType, error) {
+      s, err := args[0].AsString()
+      return VariableType{PY_TYPE_STRING, strings.ToUpper(s)}, err
+    }, []CallableArg {
+      {"val", VariableType{PY_TYPE_UNDEFINED, nil},},
+    },
+  }
+  template := new(Template)
+  err := template.Parse(`{% set greeting %}hello {{ name }}{% endset %}{% set loud | upper %}{{ greeting }}{% endset %}{{ greeting }}/{{ loud }}`)
+  if err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  expected := "hello world/HELLO WORLD"
+  if res, err := template.Render(context); err != nil {
+    t.Errorf("error rendering template: %v", err)
+  } else if res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestSetScoping(t *testing.T) {
+  source := `{% set x = "outer" %}{% for i in seq %}{% set x = i %}{{ x }}{% endfor %}{{ x }}` +
+    `{% if true %}{% set y = "if" %}{% endif %}{{ y }}`
+  expected := "12outerif"
+  context := NewContext(map[string]interface{}{"seq": []interface{}{1, 2}})
+  template := new(Template)
+  if err := template.Parse(source); err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  if res, err := template.Render(context); err != nil {
+    t.Errorf("error rendering template: %v", err)
+  } else if res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  // nothing set while rendering should end up in the caller's context
+  for _, name := range []string{"x", "y", "i", "loop"} {
+    if _, ok := context.Variables[name]; ok {
+      t.Errorf("rendering leaked the variable '%s' into the context", name)
+    }
+  }
+}
+
+func TestSetInMacroDoesNotLeak(t *testing.T) {
+  source := `{% set x = 1 %}{% macro m() %}{% set x = 2 %}{{ x }}{% endmacro %}{{ m() }}{{ x }}`
+  expected := "21"
+  if res := renderString(t, source, nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestSetExportedFromModule(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "config.html": `{% set title = "Site" %}{% set _hidden = 1 %}`,
+    "page.html": `{% import "config.html" as config %}{{ config.title }}{% from "config.html" import title as t %}{{ t }}`,
+  }))
+  expected := "SiteSite"
+  if res := renderFromEnv(t, env, "page.html", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
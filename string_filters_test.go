@@ -0,0 +1,174 @@
+package jinja2
+
+import (
+  "strings"
+  "testing"
+)
+
+func checkFilterResults(t *testing.T, env *Environment, vars map[string]interface{}, cases map[string]string) {
+  for source, expected := range cases {
+    if res := renderWithEnv(t, env, source, vars); res != expected {
+      t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+    }
+  }
+}
+
+func TestStringCaseFilters(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), nil, map[string]string{
+    `{{ "foo bar"|capitalize }}`: "Foo bar",
+    `{{ "FOO bar"|capitalize }}`: "Foo bar",
+    `{{ "Foo Bar"|lower }}|{{ "Foo Bar"|upper }}`: "foo bar|FOO BAR",
+    `{{ "foo bar"|title }}`: "Foo Bar",
+    `{{ "foo's bar"|title }}`: "Foo's Bar",
+    `{{ "foo   bar"|title }}`: "Foo   Bar",
+    `{{ "f bar f"|title }}`: "F Bar F",
+    `{{ "foo-bar"|title }}`: "Foo-Bar",
+    `{{ "FOO\tBAR"|title }}`: "Foo\tBar",
+    `{{ "foo (bar)"|title }}`: "Foo (Bar)",
+    `{{ "foo {bar}"|title }}`: "Foo {Bar}",
+    `{{ "foo [bar]"|title }}`: "Foo [Bar]",
+    `{{ "foo <bar>"|title }}`: "Foo <Bar>",
+    `{{ 42|string }}|{{ [1, 2]|string }}`: "42|[1, 2]",
+  })
+}
+
+func TestStringLayoutFilters(t *testing.T) {
+  vars := map[string]interface{}{
+    "text": "\nfoo bar\n",
+    "wrap": "Hello!\nThis is Jinja saying something.",
+  }
+  checkFilterResults(t, NewEnvironment(nil), vars, map[string]string{
+    `[{{ "  ..stays.."|trim }}]`: "[..stays..]",
+    `[{{ "  ..stays.."|trim(".") }}]`: "[  ..stays]",
+    `[{{ "foo"|center(9) }}]`: "[   foo   ]",
+    `[{{ "foo"|center(10) }}]`: "[   foo    ]",
+    `[{{ "foo"|center(2) }}]`: "[foo]",
+    `[{{ text|indent(2, false, false) }}]`: "[\n  foo bar\n]",
+    `[{{ text|indent(2, false, true) }}]`: "[\n  foo bar\n  ]",
+    `[{{ text|indent(2, true, false) }}]`: "[  \n  foo bar\n]",
+    `[{{ text|indent(2, true, true) }}]`: "[  \n  foo bar\n  ]",
+    `[{{ "a\nb"|indent }}]`: "[a\n    b]",
+    `[{{ "a\nb"|indent("> ", first=true) }}]`: "[> a\n> b]",
+    `{{ wrap|wordwrap(20) }}`: "Hello!\nThis is Jinja saying\nsomething.",
+    `{{ "Lorem ipsum dolor sit amet"|wordwrap(11, wrapstring="<br>") }}`: "Lorem ipsum<br>dolor sit<br>amet",
+    `{{ "abcdefghij klm"|wordwrap(4) }}`: "abcd\nefgh\nij\nklm",
+    `{{ "abcdefghij klm"|wordwrap(4, false) }}`: "abcdefghij\nklm",
+    `{{ "well-known fact"|wordwrap(6) }}`: "well-\nknown\nfact",
+    `{{ "well-known fact"|wordwrap(6, break_on_hyphens=false) }}`: "well-k\nnown\nfact",
+  })
+}
+
+func TestStringLayoutErrors(t *testing.T) {
+  for source, message := range map[string]string{
+    `{{ 'abc'|indent(-1) }}`: "invalid width -1 (must be >= 0)",
+    `{{ 'abc'|wordwrap(0) }}`: "invalid width 0 (must be > 0)",
+    `{{ 'abc'|wordwrap(-3) }}`: "invalid width -3 (must be > 0)",
+  } {
+    err := renderUndefinedError(t, NewEnvironment(nil), source)
+    if err == nil || !strings.Contains(err.Error(), message) {
+      t.Errorf("expected the error '%s' rendering '%s', got: %v", message, source, err)
+    }
+  }
+}
+
+func TestTruncateFilter(t *testing.T) {
+  vars := map[string]interface{}{
+    "data": strings.Repeat("foobar baz bar", 1000),
+    "smalldata": "foobar baz bar",
+  }
+  checkFilterResults(t, NewEnvironment(nil), vars, map[string]string{
+    `{{ data|truncate(15, true, ">>>") }}|{{ data|truncate(15, false, ">>>") }}|{{ smalldata|truncate(15) }}`: "foobar baz b>>>|foobar baz>>>|foobar baz bar",
+    `{{ "Joel is a slug"|truncate(7, true) }}`: "Joel...",
+    `{{ "foo bar baz qux"|truncate(9) }}`: "foo...",
+    `{{ "foo bar baz qux"|truncate(9, true) }}`: "foo ba...",
+    `{{ "foo bar baz qux"|truncate(11) }}`: "foo bar baz qux",
+    `{{ "foo bar baz qux"|truncate(11, false, "...", 0) }}`: "foo bar...",
+  })
+  if err := renderUndefinedError(t, NewEnvironment(nil), `{{ "foo bar"|truncate(2) }}`); err == nil {
+    t.Errorf("expected an error truncating to less than the length of the end")
+  }
+}
+
+func TestWordcountFilter(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), nil, map[string]string{
+    `{{ "foo bar baz"|wordcount }}`: "3",
+    `{{ "foo's bar-baz, qux!"|wordcount }}`: "5",
+    `{{ ""|wordcount }}`: "0",
+  })
+}
+
+func TestFormatFilter(t *testing.T) {
+  vars := map[string]interface{}{"greeting": "Hello", "name": "World"}
+  checkFilterResults(t, NewEnvironment(nil), vars, map[string]string{
+    `{{ "%s|%s"|format("a", "b") }}`: "a|b",
+    `{{ "%s, %s!"|format(greeting, name) }}`: "Hello, World!",
+    `{{ "%(x)s-%(y)d"|format(x="a", y=2) }}`: "a-2",
+    `{{ "%05d|%-4s|%.2f|%x|%r|%%"|format(42, "ab", 3.14159, 255, "q") }}`: "00042|ab  |3.14|ff|'q'|%",
+    `{{ "%f"|format(1) }}`: "1.000000",
+  })
+  for _, source := range []string{
+    `{{ "%s %s"|format("a") }}`,
+    `{{ "%s"|format("a", "b") }}`,
+    `{{ "%d"|format("a") }}`,
+    `{{ "%s %(x)s"|format("a", x="b") }}`,
+  } {
+    if err := renderUndefinedError(t, NewEnvironment(nil), source); err == nil {
+      t.Errorf("expected an error rendering '%s'", source)
+    }
+  }
+}
+
+func TestStriptagsFilter(t *testing.T) {
+  vars := map[string]interface{}{
+    "foo": "  <p>just a small   \n <a href=\"#\">example</a> link</p>\n<p>to a webpage</p> <!-- <p>and some commented stuff</p> -->",
+  }
+  checkFilterResults(t, NewEnvironment(nil), vars, map[string]string{
+    `{{ foo|striptags }}`: "just a small example link to a webpage",
+    `{{ "<b>fish &amp; chips</b>"|striptags }}`: "fish & chips",
+  })
+}
+
+func TestUrlencodeFilter(t *testing.T) {
+  vars := map[string]interface{}{
+    "d": map[interface{}]interface{}{"f": 1, "a b": "c&d"},
+    "pairs": []interface{}{[]interface{}{"f", 1}, []interface{}{"z", 2}},
+    "interrobang": "‽",
+  }
+  checkFilterResults(t, NewEnvironment(nil), vars, map[string]string{
+    `{{ "Hello, world!"|urlencode }}`: "Hello%2C%20world%21",
+    `{{ "a/b c"|urlencode }}`: "a/b%20c",
+    `{{ interrobang|urlencode }}`: "%E2%80%BD",
+    `{{ 42|urlencode }}`: "42",
+    `{{ d|urlencode }}`: "a+b=c%26d&f=1",
+    `{{ pairs|urlencode }}`: "f=1&z=2",
+  })
+}
+
+func TestUrlizeFilter(t *testing.T) {
+  checkFilterResults(t, NewEnvironment(nil), nil, map[string]string{
+    `{{ "foo example.org bar"|urlize }}`: `foo <a href="https://example.org" rel="noopener">example.org</a> bar`,
+    `{{ "foo http://www.example.com/ bar"|urlize }}`: `foo <a href="http://www.example.com/" rel="noopener">http://www.example.com/</a> bar`,
+    `{{ "foo www.example.com bar"|urlize }}`: `foo <a href="https://www.example.com" rel="noopener">www.example.com</a> bar`,
+    `{{ "foo mailto:email@example.com bar"|urlize }}`: `foo <a href="mailto:email@example.com">email@example.com</a> bar`,
+    `{{ "foo email@example.com bar"|urlize }}`: `foo <a href="mailto:email@example.com">email@example.com</a> bar`,
+    `{{ "(see http://example.com/x)."|urlize }}`: `(see <a href="http://example.com/x" rel="noopener">http://example.com/x</a>).`,
+    `{{ "http://example.com/(x)"|urlize }}`: `<a href="http://example.com/(x)" rel="noopener">http://example.com/(x)</a>`,
+    `{{ "http://example.com/abcdef"|urlize(10) }}`: `<a href="http://example.com/abcdef" rel="noopener">http://exa...</a>`,
+    `{{ "http://example.com"|urlize(nofollow=true) }}`: `<a href="http://example.com" rel="nofollow noopener">http://example.com</a>`,
+    `{{ "http://example.com"|urlize(target="_blank", rel="ext") }}`: `<a href="http://example.com" rel="ext noopener" target="_blank">http://example.com</a>`,
+    `{{ "<b> http://example.com"|urlize }}`: `&lt;b&gt; <a href="http://example.com" rel="noopener">http://example.com</a>`,
+  })
+}
+
+func TestStringFiltersKeepMarkup(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.Autoescape = func(string) bool { return true }
+  vars := map[string]interface{}{"name": "<b>"}
+  checkFilterResults(t, env, vars, map[string]string{
+    `{{ name|upper }}`: "&lt;B&gt;",
+    `{{ "<i>"|safe|upper }}`: "<I>",
+    `{{ "<i>x</i>"|safe|truncate(5, true, "<", 0) }}`: "<i>x&lt;",
+    `{{ "a b"|urlize }}|{{ "<a>"|striptags }}`: "a b|",
+    `{{ "x"|safe|center(3) }}|{{ name|string }}`: " x |&lt;b&gt;",
+  })
+}
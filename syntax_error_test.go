@@ -0,0 +1,64 @@
+package jinja2
+
+import (
+  "errors"
+  "strings"
+  "testing"
+)
+
+func parseError(t *testing.T, source string) *TemplateSyntaxError {
+  template := new(Template)
+  err := template.Parse(source)
+  var syntax_err *TemplateSyntaxError
+  if !errors.As(err, &syntax_err) {
+    t.Fatalf("expected a syntax error for '%s', got: %v", source, err)
+  }
+  return syntax_err
+}
+
+func TestSyntaxErrorPositions(t *testing.T) {
+  for _, test := range []struct {
+    source string
+    line, column int
+    message string
+  }{
+    {"Hello {{ name", 1, 7, "unclosed tag"},
+    {"Hello {% if x %}", 1, 7, "Missing 'endif'"},
+    {"a\n  {% frobnicate %}", 2, 3, "unknown tag 'frobnicate'"},
+    {"{% if x %}\n{% else junk %}{% endif %}", 2, 1, "else statements"},
+    {"{% endfor %}", 1, 1, "invalid token found"},
+    {"{% %}", 1, 1, "tag name expected"},
+    {"{% raw %}never closed", 1, 1, "endraw"},
+    {"line one\n{% if x y %}{% endif %}", 2, 9, "unexpected"},
+    {"{{ a }}\n\t{{- [1 + }}", 2, 9, "unexpected"},
+    {"{% if x %}{% else %}\n{% else %}{% endif %}", 2, 1, "unexpected 'else' found after an 'else' tag"},
+    {"{% if x %}{% else %}a{% elif y %}{% endif %}", 1, 22, "unexpected 'elif' found after an 'else' tag"},
+    {"{% for x in y %}{% else %}{% else %}{% endfor %}", 1, 27, "unexpected 'else' found after an 'else' tag"},
+  } {
+    err := parseError(t, test.source)
+    if err.Line != test.line || err.Column != test.column || !strings.Contains(err.Message, test.message) {
+      t.Errorf("wrong syntax error for '%s'. Got: %d:%d '%s' but expected %d:%d '%s'", test.source, err.Line, err.Column, err.Message, test.line, test.column, test.message)
+    }
+  }
+}
+
+func TestSyntaxErrorExcerpt(t *testing.T) {
+  env := NewEnvironment(NewDictLoader(map[string]string{
+    "bad.html": "<ul>\n\t<li>{% for x in %}</li>\n</ul>",
+  }))
+  _, err := env.GetTemplate("bad.html")
+  var syntax_err *TemplateSyntaxError
+  if !errors.As(err, &syntax_err) {
+    t.Fatalf("expected a syntax error, got: %v", err)
+  }
+  if syntax_err.Name != "bad.html" || syntax_err.Line != 2 {
+    t.Errorf("wrong syntax error location: %s:%d", syntax_err.Name, syntax_err.Line)
+  }
+  if !strings.HasPrefix(err.Error(), "bad.html:2:") {
+    t.Errorf("the error message does not start with the location: %s", err.Error())
+  }
+  expected := "  \t<li>{% for x in %}</li>\n  \t" + strings.Repeat(" ", syntax_err.Column-2) + "^"
+  if excerpt := syntax_err.Excerpt(); excerpt != expected {
+    t.Errorf("Excerpt was incorrect. Got:\n%s\nbut expected:\n%s", excerpt, expected)
+  }
+}
@@ -1,81 +1,442 @@
 package jinja2
 
 import (
+  "context"
   "errors"
+  "io"
+  "io/ioutil"
+  "sort"
+  "strings"
   "unicode"
 )
 
 type Template struct {
+  Name string
+  Filename string
   data string
   template_chunks []Renderable
+  blocks map[string]*BlockChunk
+  env *Environment
+  autoescape bool
+}
+
+// The renderState tracks everything needed while rendering a single
+// template which isn't a variable: the template currently rendering,
+// the template it extends (if any), and the stack of definitions for
+// each block, from the child-most template to the base layout.
+type renderState struct {
+  template *Template
+  env *Environment
+  root *Context
+  parent *Template
+  blocks map[string][]*BlockChunk
+}
+func (self *renderState) addBlocks(t *Template) {
+  for name, block := range t.blocks {
+    self.blocks[name] = append(self.blocks[name], block)
+  }
+  // `self` gives access to every block known so far as a callable
+  self_dict := make(map[VariableType]VariableType)
+  for name, _ := range self.blocks {
+    block_name := name
+    self_dict[VariableType{PY_TYPE_STRING, block_name}] = VariableType{PY_TYPE_CALLABLE, PyCallable{
+      func(args []VariableType) (VariableType, error) {
+        var res strings.Builder
+        if err := RenderBlock(self.root.captureWriter(&res), self.root, block_name, 0); err != nil {
+          return VariableType{PY_TYPE_UNDEFINED, nil}, leavingFrame(err, "block '" + block_name + "'")
+        }
+        return self.root.rendered(res.String()), nil
+      }, []CallableArg{},
+    }}
+  }
+  self.root.Variables["self"] = VariableType{PY_TYPE_DICT, self_dict}
 }
 
 func (self *Template) Parse(data string) error {
+  config := LexerConfig{}
+  if self.env != nil {
+    config = self.env.lexerConfig()
+  }
   self.data = data
-  self.template_chunks = make([]Renderable, 0)
-
-  tokens := Tokenize(self.data)
+  tokens, err := TokenizeWithConfig(data, config)
+  if err != nil {
+    return self.syntaxError(err)
+  }
+  chunks := make([]Renderable, 0)
   for pos := 0; pos < len(tokens); {
     new_pos, contained_chunks, err := ParseBlocks(tokens, pos, "")
     if err != nil {
-      return err
+      return self.syntaxError(err)
     }
-    self.template_chunks = append(self.template_chunks, contained_chunks...)
+    chunks = append(chunks, contained_chunks...)
     pos = new_pos
   }
+  return self.setChunks(data, chunks)
+}
+
+// setChunks sets the parsed chunks of the template and finds the
+// blocks defined in them.
+func (self *Template) setChunks(data string, chunks []Renderable) error {
+  self.data = data
+  self.template_chunks = chunks
+  self.blocks = make(map[string]*BlockChunk)
+  if err := FindBlocks(self.template_chunks, self.blocks); err != nil {
+    return err
+  }
+  for _, block := range self.blocks {
+    block.template = self
+  }
+  return nil
+}
+
+// syntaxError fills in the template name and source on syntax errors,
+// so they can show where in the template they happened.
+func (self *Template) syntaxError(err error) error {
+  var syntax_err *TemplateSyntaxError
+  if errors.As(err, &syntax_err) {
+    syntax_err.Name = self.Name
+    syntax_err.Filename = self.Filename
+    syntax_err.Source = self.data
+  }
+  return err
+}
+
+// FindBlocks collects every block defined in the chunks, including
+// blocks nested inside other tags, by name.
+func FindBlocks(chunks []Renderable, blocks map[string]*BlockChunk) error {
+  for _, chunk := range chunks {
+    if block, ok := chunk.(*BlockChunk); ok {
+      name := *block.BlockAst.Name
+      if _, ok := blocks[name]; ok {
+        return errors.New("block '" + name + "' defined twice")
+      }
+      blocks[name] = block
+    }
+    if err := FindBlocks(SubChunks(chunk), blocks); err != nil {
+      return err
+    }
+  }
   return nil
 }
 
+// Render renders the template to a string.
 func (self *Template) Render(c *Context) (string, error) {
-  res := ""
-  for _, chunk := range self.template_chunks {
-    c_res, err := chunk.Render(c)
-    if err != nil {
-      return "", err
-    } else {
-      res = res + c_res
+  var res strings.Builder
+  if err := self.RenderTo(&res, c); err != nil {
+    return "", err
+  }
+  return res.String(), nil
+}
+
+// RenderTo renders the template, writing the output to the writer as
+// it goes rather than holding it all in memory. When rendering fails,
+// the output up to the error has already been written. The template
+// makes many small writes, so writers with a cost per write should be
+// buffered.
+func (self *Template) RenderTo(w io.Writer, c *Context) error {
+  return self.RenderToContext(context.Background(), w, c)
+}
+
+// RenderContext renders the template to a string, stopping with the
+// context's error if it is cancelled or its deadline passes.
+func (self *Template) RenderContext(ctx context.Context, c *Context) (string, error) {
+  var res strings.Builder
+  if err := self.RenderToContext(ctx, &res, c); err != nil {
+    return "", err
+  }
+  return res.String(), nil
+}
+
+// RenderToContext renders the template to the writer, stopping with
+// the context's error if it is cancelled or its deadline passes. The
+// render is also held to the environment's Limits, and fails with a
+// LimitExceededError when it goes over one of them.
+func (self *Template) RenderToContext(ctx context.Context, w io.Writer, c *Context) error {
+  rc := self.newRenderContext(c)
+  if rc.budget == nil {
+    // this is the start of the render, rather than a template being
+    // included by one already rendering
+    limits := Limits{}
+    if rc.Env != nil {
+      limits = rc.Env.Limits
+    }
+    rc.budget = newRenderBudget(ctx, limits)
+    w = rc.budget.writer(w)
+  }
+  return self.render(w, rc)
+}
+
+// MakeModule renders the template and returns the macros and variables
+// it defines at the top level as a module, which is what the import
+// tags bind. Names starting with an underscore are private and are
+// not exported.
+func (self *Template) MakeModule(c *Context) (*TemplateModule, error) {
+  rc := self.newRenderContext(c)
+  if err := self.render(ioutil.Discard, rc); err != nil {
+    return nil, err
+  }
+  module := &TemplateModule{Name: self.Name, Exports: make(map[string]VariableType)}
+  for name, v := range rc.Variables {
+    if name == "self" || strings.HasPrefix(name, "_") {
+      continue
+    }
+    module.Exports[name] = v
+  }
+  return module, nil
+}
+
+// newRenderContext creates the layer of the context the template
+// renders into, so that nothing it sets leaks back into the caller's
+// context. A template from an environment always renders with that
+// environment, whichever one the context came from, so that its
+// sandbox, loader and limits apply.
+func (self *Template) newRenderContext(c *Context) *Context {
+  rc := c.derive()
+  if self.env != nil {
+    rc.Env = self.env
+  }
+  rc.autoescape = self.autoescape
+  rc.template = self
+  rc.state = &renderState{
+    template: self,
+    env: rc.Env,
+    root: rc,
+    blocks: make(map[string][]*BlockChunk),
+  }
+  rc.state.addBlocks(self)
+  return rc
+}
+
+func (self *Template) render(w io.Writer, rc *Context) error {
+  for tmpl := self; tmpl != nil; {
+    for _, chunk := range tmpl.template_chunks {
+      // once the template extends another one, its output is
+      // replaced by the output of the parent
+      out := w
+      if rc.state.parent != nil {
+        out = ioutil.Discard
+      }
+      if err := rc.budget.check(); err != nil {
+        return err
+      }
+      if err := chunk.Render(out, rc); err != nil {
+        return chunkError(chunk, err, rc)
+      }
+    }
+    tmpl = rc.state.parent
+    if tmpl != nil {
+      rc.state.parent = nil
+      rc.state.template = tmpl
+      rc.template = tmpl
+      rc.state.addBlocks(tmpl)
     }
   }
-  return res, nil
+  return nil
+}
+
+func FindTokenBoundaries(input string) ([]TokenBoundary, error) {
+  return FindTokenBoundariesWithConfig(input, LexerConfig{})
 }
 
-func FindTokenBoundaries(input string) []TokenBoundary {
+// FindTokenBoundariesWithConfig returns the start and end delimiter of
+// every tag in the input as pairs. Quotes and brackets are only tracked inside
+// of tags, so an end delimiter inside a string or a dict literal does
+// not close the tag. Everything between a raw tag and its endraw is
+// skipped.
+func FindTokenBoundariesWithConfig(input string, config LexerConfig) ([]TokenBoundary, error) {
+  config = config.withDefaults()
+  lines := newLineIndex(input)
+  starts := []string{config.BlockStartString, config.VariableStartString, config.CommentStartString}
   bounds := make([]TokenBoundary, 0)
-  cur_line := 0
-  cur_line_pos := 0
-  in_quotes := false
-  quote_char := byte(0)
-  for i := 0; i < len(input); i++ {
-    if in_quotes {
-      if input[i] == quote_char {
-        in_quotes = false
-        quote_char = byte(0)
+  pos := 0
+  for pos < len(input) {
+    start, delim := findStartDelimiter(input, pos, starts)
+    if config.LineStatementPrefix != "" {
+      if idx := findLineStatement(input, pos, config.LineStatementPrefix); idx != -1 && (start == -1 || idx < start || idx == start && len(config.LineStatementPrefix) > len(delim)) {
+        start = idx
+        delim = config.LineStatementPrefix
+      }
+    }
+    if config.LineCommentPrefix != "" {
+      if idx := strings.Index(input[pos:], config.LineCommentPrefix); idx != -1 && (start == -1 || idx + pos < start || idx + pos == start && len(config.LineCommentPrefix) > len(delim)) {
+        start = idx + pos
+        delim = config.LineCommentPrefix
+      }
+    }
+    if start == -1 {
+      break
+    }
+    end_delim := config.endDelimiter(delim)
+    var end int
+    if delim == config.LineStatementPrefix || delim == config.LineCommentPrefix {
+      // line statements run to the end of the line, unless a bracket
+      // is still open, and take the newline with them. Line comments
+      // leave the newline in place.
+      if delim == config.LineStatementPrefix {
+        end = findTagEnd(input, start+len(delim), "\n")
+      } else {
+        end = strings.Index(input[start:], "\n")
+        if end != -1 {
+          end += start
+        }
+        end_delim = ""
+      }
+      if end == -1 {
+        end = len(input)
+        end_delim = ""
+      }
+    } else if delim == config.CommentStartString {
+      // comments can contain anything, including unbalanced quotes
+      end = strings.Index(input[start+len(delim):], end_delim)
+      if end != -1 {
+        end += start + len(delim)
       }
-    } else if input[i] == '\n' {
-      cur_line++
     } else {
-      if input[i] == '\'' || input[i] == '"' {
-        in_quotes = true
-        quote_char = input[i]
-      } else if input[i] == '{' && i < len(input) {
-        sub := input[i:i+2]
-        if sub == "{%" || sub == "{{" || sub == "{#" {
-          token := TokenBoundary{sub, i, cur_line, cur_line_pos}
-          bounds = append(bounds, token)
-          i += 1
+      end = findTagEnd(input, start+len(delim), end_delim)
+    }
+    if end == -1 {
+      line, column := lines.position(start)
+      return nil, &TemplateSyntaxError{Message: "unclosed tag, missing '" + end_delim + "'", Line: line, Column: column, Source: input}
+    }
+    bounds = append(bounds, lines.boundary(delim, start), lines.boundary(end_delim, end))
+    pos = end + len(end_delim)
+
+    if delim == config.BlockStartString && isTag(input[start+len(delim):end], "raw") {
+      // the contents of a raw block are not searched for tags, so
+      // jump straight to the matching endraw
+      found := false
+      for pos < len(input) {
+        raw_start := strings.Index(input[pos:], config.BlockStartString)
+        if raw_start == -1 {
+          break
         }
-      } else if input[i] == '}' && i > 0 {
-        sub := input[i-1:i+1]
-        if sub == "%}" || sub == "}}" || sub == "#}" {
-          if sub != "}}" || i >= len(input) - 1 || input[i+1] != '}' {
-            token := TokenBoundary{sub, i, cur_line, cur_line_pos}
-            bounds = append(bounds, token)
-          }
+        raw_start += pos
+        raw_end := findTagEnd(input, raw_start+len(config.BlockStartString), config.BlockEndString)
+        if raw_end == -1 {
+          break
+        }
+        if isTag(input[raw_start+len(config.BlockStartString):raw_end], "endraw") {
+          bounds = append(bounds, lines.boundary(config.BlockStartString, raw_start), lines.boundary(config.BlockEndString, raw_end))
+          pos = raw_end + len(config.BlockEndString)
+          found = true
+          break
         }
+        pos = raw_start + len(config.BlockStartString)
+      }
+      if !found {
+        line, column := lines.position(start)
+        return nil, &TemplateSyntaxError{Message: "Missing matching 'endraw' for a raw block.", Line: line, Column: column, Source: input}
+      }
+    }
+  }
+  return bounds, nil
+}
+
+// findStartDelimiter finds the first start delimiter at or after pos.
+// When more than one matches at the same place, the longest wins.
+func findStartDelimiter(input string, pos int, starts []string) (int, string) {
+  start := -1
+  delim := ""
+  for _, d := range starts {
+    idx := strings.Index(input[pos:], d)
+    if idx == -1 {
+      continue
+    }
+    idx += pos
+    if start == -1 || idx < start || idx == start && len(d) > len(delim) {
+      start = idx
+      delim = d
+    }
+  }
+  return start, delim
+}
+
+// findLineStatement finds the first line statement prefix at or after
+// pos which has nothing but whitespace before it on its line.
+func findLineStatement(input string, pos int, prefix string) int {
+  for pos < len(input) {
+    idx := strings.Index(input[pos:], prefix)
+    if idx == -1 {
+      return -1
+    }
+    idx += pos
+    line_start := strings.LastIndex(input[:idx], "\n") + 1
+    if strings.Trim(input[line_start:idx], " \t") == "" {
+      return idx
+    }
+    pos = idx + len(prefix)
+  }
+  return -1
+}
+
+// findTagEnd finds the end delimiter of a tag starting at pos, skipping
+// over strings and anything inside of brackets. If the brackets are
+// never balanced, the first end delimiter outside of a string is used
+// so the error is reported by the statement parser instead.
+func findTagEnd(input string, pos int, end_delim string) int {
+  depth := 0
+  quote_char := byte(0)
+  first_end := -1
+  for i := pos; i < len(input); i++ {
+    ch := input[i]
+    if quote_char != 0 {
+      if ch == '\\' {
+        i++
+      } else if ch == quote_char {
+        quote_char = 0
+      }
+      continue
+    }
+    if strings.HasPrefix(input[i:], end_delim) {
+      if depth == 0 {
+        return i
+      } else if first_end == -1 {
+        first_end = i
+      }
+    }
+    switch ch {
+    case '\'', '"':
+      quote_char = ch
+    case '(', '[', '{':
+      depth++
+    case ')', ']', '}':
+      if depth > 0 {
+        depth--
       }
     }
   }
-  return bounds
+  return first_end
+}
+
+// isTag checks if the inside of a block tag is just the given keyword.
+func isTag(inner string, keyword string) bool {
+  inner, _, _ = tagMarkers(inner, true)
+  return strings.TrimSpace(inner) == keyword
+}
+
+// lineIndex holds the offset of the start of each line in the source,
+// for finding the line and column of an offset.
+type lineIndex []int
+
+func newLineIndex(input string) lineIndex {
+  lines := lineIndex{0}
+  for i := 0; i < len(input); i++ {
+    if input[i] == '\n' {
+      lines = append(lines, i+1)
+    }
+  }
+  return lines
+}
+
+// position returns the 1-based line and column of the offset.
+func (self lineIndex) position(pos int) (int, int) {
+  line := sort.Search(len(self), func(i int) bool { return self[i] > pos }) - 1
+  return line + 1, pos - self[line] + 1
+}
+
+func (self lineIndex) boundary(delim string, pos int) TokenBoundary {
+  line, _ := self.position(pos)
+  return TokenBoundary{delim, pos, line - 1, self[line-1]}
 }
 
 func GetNextId(input string, start int) (string, int, error) {
@@ -101,143 +462,337 @@ func GetNextId(input string, start int) (string, int, error) {
   }
 }
 
-func Tokenize(input string) []Token {
-  res := FindTokenBoundaries(input)
+// LexerConfig holds the options which change how a template source is
+// split into tokens. The zero value matches the Jinja2 defaults.
+type LexerConfig struct {
+  // TrimBlocks removes the first newline after a block or comment tag.
+  TrimBlocks bool
+  // LstripBlocks strips the spaces and tabs from the start of a line
+  // up to a block or comment tag.
+  LstripBlocks bool
+  // KeepTrailingNewline keeps the single trailing newline of the
+  // source, which is removed by default.
+  KeepTrailingNewline bool
+  // The delimiters of each kind of tag, which can be any length. When
+  // left empty the Jinja2 defaults are used.
+  BlockStartString string
+  BlockEndString string
+  VariableStartString string
+  VariableEndString string
+  CommentStartString string
+  CommentEndString string
+  // LineStatementPrefix starts a block statement which runs to the end
+  // of the line, when it is the first thing on the line.
+  LineStatementPrefix string
+  // LineCommentPrefix comments out the rest of the line.
+  LineCommentPrefix string
+}
+
+func (self LexerConfig) withDefaults() LexerConfig {
+  if self.BlockStartString == "" { self.BlockStartString = "{%" }
+  if self.BlockEndString == "" { self.BlockEndString = "%}" }
+  if self.VariableStartString == "" { self.VariableStartString = "{{" }
+  if self.VariableEndString == "" { self.VariableEndString = "}}" }
+  if self.CommentStartString == "" { self.CommentStartString = "{#" }
+  if self.CommentEndString == "" { self.CommentEndString = "#}" }
+  return self
+}
+
+func (self LexerConfig) endDelimiter(start string) string {
+  switch start {
+  case self.BlockStartString:
+    return self.BlockEndString
+  case self.VariableStartString:
+    return self.VariableEndString
+  case self.LineStatementPrefix:
+    return "\n"
+  }
+  return self.CommentEndString
+}
+
+// how the text after a tag is trimmed
+const (
+  trimNone = iota
+  trimNewline
+  trimWhitespace
+)
+
+// tagMarkers removes the whitespace control markers from the inside of
+// a tag, returning what is left along with the markers found at the
+// start and end ('-', '+' or 0 for none).
+func tagMarkers(inner string, allow_plus bool) (string, byte, byte) {
+  before := byte(0)
+  after := byte(0)
+  if len(inner) > 0 && (inner[0] == '-' || allow_plus && inner[0] == '+') {
+    before = inner[0]
+    inner = inner[1:]
+  }
+  if len(inner) > 0 && (inner[len(inner)-1] == '-' || allow_plus && inner[len(inner)-1] == '+') {
+    after = inner[len(inner)-1]
+    inner = inner[:len(inner)-1]
+  }
+  return inner, before, after
+}
+
+// trimText returns the text between two tags with the whitespace
+// control of the tag before it (trim_start) and the tag after it
+// applied. lstrip_end only strips the indentation of the following tag
+// when nothing but whitespace precedes it on its line.
+func trimText(input string, start int, end int, trim_start int, strip_end bool, lstrip_end bool) string {
+  if strip_end {
+    end = start + len(strings.TrimRight(input[start:end], " \t\r\n"))
+  } else if lstrip_end {
+    k := end
+    for k > start && (input[k-1] == ' ' || input[k-1] == '\t') {
+      k--
+    }
+    if k == 0 || input[k-1] == '\n' {
+      end = k
+    }
+  }
+  text := input[start:end]
+  switch trim_start {
+  case trimWhitespace:
+    text = strings.TrimLeft(text, " \t\r\n")
+  case trimNewline:
+    if strings.HasPrefix(text, "\r\n") {
+      text = text[2:]
+    } else if strings.HasPrefix(text, "\n") {
+      text = text[1:]
+    }
+  }
+  return text
+}
+
+// trimAfter works out how the text following a tag is trimmed from the
+// marker at the end of the tag.
+func (self LexerConfig) trimAfter(marker byte, is_block bool) int {
+  if marker == '-' {
+    return trimWhitespace
+  } else if self.TrimBlocks && is_block && marker != '+' {
+    return trimNewline
+  }
+  return trimNone
+}
+
+func Tokenize(input string) ([]Token, error) {
+  return TokenizeWithConfig(input, LexerConfig{})
+}
+
+func TokenizeWithConfig(input string, config LexerConfig) ([]Token, error) {
+  if !config.KeepTrailingNewline {
+    if strings.HasSuffix(input, "\r\n") {
+      input = input[:len(input)-2]
+    } else if strings.HasSuffix(input, "\n") {
+      input = input[:len(input)-1]
+    }
+  }
+  config = config.withDefaults()
+  res, err := FindTokenBoundariesWithConfig(input, config)
+  if err != nil {
+    return nil, err
+  }
+  lines := newLineIndex(input)
+  base_at := func(pos int, content_pos int, strip_before bool, strip_after bool) TokenBase {
+    line, column := lines.position(pos)
+    return TokenBase{pos, line, strip_before, strip_after, column, content_pos}
+  }
+  syntax_error := func(pos int, message string) error {
+    line, column := lines.position(pos)
+    return &TemplateSyntaxError{Message: message, Line: line, Column: column, Source: input}
+  }
+  trim_next := trimNone
   cur_pos := 0
   end_pos := 0
-  cur_line := 1
-  cur_line_pos := 0
   tokens := make([]Token, 0)
   in_raw := false
   for i := 0; i < len(res); {
     t1 := res[i]
     t2 := res[i+1]
     if in_raw {
-      if t1.Val != "{%" && t2.Val != "%}" {
-        i += 1
-        continue
-      }
-      strip_before := false
-      strip_after := false
-      block_statement := input[t1.Pos+2:t2.Pos-1]
-      if len(block_statement) > 0 && block_statement[0] == '-' {
-        block_statement = block_statement[1:]
-        strip_before = true
+      // the boundary finder skips everything up to the endraw tag
+      block_statement, before, after := tagMarkers(input[t1.Pos+len(t1.Val):t2.Pos], true)
+      strip_before := before == '-'
+      strip_after := after == '-'
+      end_pos = t1.Pos
+      if end_pos > cur_pos {
+        raw_token := tokens[len(tokens)-1].(RawToken)
+        raw_token.Content = raw_token.Content + trimText(input, cur_pos, end_pos, trim_next, strip_before, config.LstripBlocks && before != '+')
+        tokens[len(tokens)-1] = raw_token
       }
-      if len(block_statement) > 0 && block_statement[len(block_statement)-1] == '-' {
-        block_statement = block_statement[:len(block_statement)-1]
-        strip_after = true
+      if strings.TrimSpace(block_statement) != "endraw" {
+        return nil, syntax_error(t1.Pos, "endraw statements can't have any thing else with them")
       }
-      id, idpos, err := GetNextId(block_statement, 0)
-      if err != nil {
-        panic(err)
+      endraw_token := EndrawToken{TokenBase: base_at(t1.Pos, t1.Pos+len(t1.Val), strip_before, strip_after)}
+      tokens = append(tokens, endraw_token)
+      in_raw = false
+      trim_next = config.trimAfter(after, true)
+      cur_pos = t2.Pos + len(t2.Val)
+      i += 2
+    } else {
+      // the whitespace control markers decide how the text on either
+      // side of the tag is trimmed
+      is_block := t1.Val != config.VariableStartString
+      is_line := t1.Val == config.LineStatementPrefix || t1.Val == config.LineCommentPrefix
+      block_statement, before, after := input[t1.Pos+len(t1.Val):t2.Pos], byte(0), byte(0)
+      content_pos := t1.Pos + len(t1.Val)
+      if is_line {
+        // line statements have no markers, but may end in a colon
+        trimmed := strings.TrimLeft(block_statement, " \t")
+        content_pos += len(block_statement) - len(trimmed)
+        block_statement = strings.TrimSuffix(strings.TrimSpace(trimmed), ":")
       } else {
-        if id != "endraw" {
-          i += 1
-          continue
-        }
-        next_id, _, _ := GetNextId(block_statement, idpos)
-        if next_id != "" {
-          panic("endraw statements can't have any thing else with them")
-        }
-        end_pos = t1.Pos
-        if end_pos > cur_pos {
-          raw_token := tokens[len(tokens)-1].(RawToken)
-          raw_token.Content = raw_token.Content + input[cur_pos:end_pos]
-          tokens[len(tokens)-1] = raw_token
+        block_statement, before, after = tagMarkers(block_statement, is_block)
+        if before != 0 {
+          content_pos += 1
         }
-        endraw_token := EndrawToken{TokenBase: TokenBase{t1.Pos+1, t1.Line+1, strip_before, strip_after}}
-        tokens = append(tokens, endraw_token)
-        in_raw = false
-        cur_pos = t2.Pos + 1
-        i += 2
-      }
-    } else {
-      if t1.Val == "{{" && t2.Val != "}}" || t1.Val == "{%" && t2.Val != "%}" || t1.Val == "{#" && t2.Val != "#}" {
-        panic("mismatched boundaries: " + t2.Val + " was found immediately after " + t1.Val)
       }
+      strip_before := before == '-'
+      base := base_at(t1.Pos, content_pos, strip_before, after == '-')
       end_pos = t1.Pos
       if end_pos > cur_pos {
-        // text block
-        text_token := TextToken{Text: input[cur_pos:end_pos], TokenBase: TokenBase{t1.Pos, t1.Line, false, false}}
-        tokens = append(tokens, text_token)
+        // text block. The indentation of a line statement and the
+        // spaces before a line comment always go with them.
+        lstrip := config.LstripBlocks && is_block && before != '+' || t1.Val == config.LineStatementPrefix
+        text := trimText(input, cur_pos, end_pos, trim_next, strip_before, lstrip)
+        if t1.Val == config.LineCommentPrefix {
+          text = strings.TrimRight(text, " \t")
+        }
+        if text != "" {
+          text_token := TextToken{Text: text, TokenBase: base_at(cur_pos, cur_pos, false, false)}
+          tokens = append(tokens, text_token)
+        }
       }
-      cur_line = t2.Line
-      cur_line_pos = t2.NewLinePos
-      if t1.Val == "{{" {
+      trim_next = config.trimAfter(after, is_block)
+      if t1.Val == config.VariableStartString {
         // variable block
-        var_data := input[t1.Pos+2:t2.Pos-1]
-        var_token := VariableToken{Content: var_data, TokenBase: TokenBase{t1.Pos, t1.Line, false, false}}
+        var_token := VariableToken{Content: block_statement, TokenBase: base}
         tokens = append(tokens, var_token)
-      } else if t1.Val == "{%" {
+      } else if t1.Val == config.BlockStartString || t1.Val == config.LineStatementPrefix {
         // if/for/something block
-        strip_before := false
-        strip_after := false
-        block_statement := input[t1.Pos+2:t2.Pos-1]
-        if len(block_statement) > 0 && block_statement[0] == '-' {
-          block_statement = block_statement[1:]
-          strip_before = true
-        }
-        if len(block_statement) > 0 && block_statement[len(block_statement)-1] == '-' {
-          block_statement = block_statement[:len(block_statement)-1]
-          strip_after = true
-        }
         id, idpos, err := GetNextId(block_statement, 0)
         if err != nil {
-          panic(err)
+          return nil, syntax_error(t1.Pos, "tag name expected")
         } else {
           var token_thing Token
           switch id {
           case "if":
-            token_thing = IfToken{IfStatement: block_statement, TokenBase: TokenBase{t1.Pos+1, t1.Line+1, strip_before, strip_after}}
+            token_thing = IfToken{IfStatement: block_statement, TokenBase: base}
           case "elif":
-            token_thing = ElifToken{ElifStatement: block_statement, TokenBase: TokenBase{t1.Pos+1, t1.Line+1, strip_before, strip_after}}
+            token_thing = ElifToken{ElifStatement: block_statement, TokenBase: base}
           case "else":
             next_id, _, _ := GetNextId(block_statement, idpos)
             if next_id != "" {
-              panic("else statements can't have any thing else with them")
+              return nil, syntax_error(t1.Pos, "else statements can't have any thing else with them")
             }
             //fmt.Println("CREATING ELSE TOKEN FROM", block_statement)
-            token_thing = ElseToken{TokenBase: TokenBase{t1.Pos+1, t1.Line+1, strip_before, strip_after}}
+            token_thing = ElseToken{TokenBase: base}
           case "endif":
             next_id, _, _ := GetNextId(block_statement, idpos)
             if next_id != "" {
-              panic("endif statements can't have any thing else with them")
+              return nil, syntax_error(t1.Pos, "endif statements can't have any thing else with them")
             }
-            token_thing = EndifToken{TokenBase: TokenBase{t1.Pos+1, t1.Line+1, strip_before, strip_after}}
+            token_thing = EndifToken{TokenBase: base}
           case "for":
-            token_thing = ForToken{ForStatement: block_statement, TokenBase: TokenBase{t1.Pos+1, t1.Line+1, strip_before, strip_after}}
+            token_thing = ForToken{ForStatement: block_statement, TokenBase: base}
           case "endfor":
             next_id, _, _ := GetNextId(block_statement, idpos)
             if next_id != "" {
-              panic("endfor statements can't have any thing else with them")
+              return nil, syntax_error(t1.Pos, "endfor statements can't have any thing else with them")
+            }
+            token_thing = EndforToken{TokenBase: base}
+          case "extends":
+            token_thing = ExtendsToken{ExtendsStatement: block_statement, TokenBase: base}
+          case "block":
+            token_thing = BlockToken{BlockStatement: block_statement, TokenBase: base}
+          case "endblock":
+            // the block name is optional on the endblock tag
+            block_name := strings.TrimSpace(block_statement[idpos:])
+            if strings.ContainsAny(block_name, " \t\n") {
+              return nil, syntax_error(t1.Pos, "endblock statements can only have the name of the block with them")
+            }
+            token_thing = EndblockToken{Name: block_name, TokenBase: base}
+          case "include":
+            token_thing = IncludeToken{IncludeStatement: block_statement, TokenBase: base}
+          case "macro":
+            token_thing = MacroToken{MacroStatement: block_statement, TokenBase: base}
+          case "endmacro":
+            next_id, _, _ := GetNextId(block_statement, idpos)
+            if next_id != "" {
+              return nil, syntax_error(t1.Pos, "endmacro statements can't have any thing else with them")
+            }
+            token_thing = EndmacroToken{TokenBase: base}
+          case "call":
+            token_thing = CallToken{CallStatement: block_statement, TokenBase: base}
+          case "endcall":
+            next_id, _, _ := GetNextId(block_statement, idpos)
+            if next_id != "" {
+              return nil, syntax_error(t1.Pos, "endcall statements can't have any thing else with them")
+            }
+            token_thing = EndcallToken{TokenBase: base}
+          case "import":
+            token_thing = ImportToken{ImportStatement: block_statement, TokenBase: base}
+          case "from":
+            token_thing = FromImportToken{FromImportStatement: block_statement, TokenBase: base}
+          case "set":
+            token_thing = SetToken{SetStatement: block_statement, TokenBase: base}
+          case "endset":
+            next_id, _, _ := GetNextId(block_statement, idpos)
+            if next_id != "" {
+              return nil, syntax_error(t1.Pos, "endset statements can't have any thing else with them")
             }
-            token_thing = EndforToken{TokenBase: TokenBase{t1.Pos+1, t1.Line+1, strip_before, strip_after}}
+            token_thing = EndsetToken{TokenBase: base}
+          case "filter":
+            token_thing = FilterToken{FilterStatement: block_statement, TokenBase: base}
+          case "endfilter":
+            next_id, _, _ := GetNextId(block_statement, idpos)
+            if next_id != "" {
+              return nil, syntax_error(t1.Pos, "endfilter statements can't have any thing else with them")
+            }
+            token_thing = EndfilterToken{TokenBase: base}
+          case "autoescape":
+            token_thing = AutoescapeToken{AutoescapeStatement: block_statement, TokenBase: base}
+          case "endautoescape":
+            next_id, _, _ := GetNextId(block_statement, idpos)
+            if next_id != "" {
+              return nil, syntax_error(t1.Pos, "endautoescape statements can't have any thing else with them")
+            }
+            token_thing = EndautoescapeToken{TokenBase: base}
+          case "with":
+            token_thing = WithToken{WithStatement: block_statement, TokenBase: base}
+          case "endwith":
+            next_id, _, _ := GetNextId(block_statement, idpos)
+            if next_id != "" {
+              return nil, syntax_error(t1.Pos, "endwith statements can't have any thing else with them")
+            }
+            token_thing = EndwithToken{TokenBase: base}
           case "raw":
             next_id, _, _ := GetNextId(block_statement, idpos)
             if next_id != "" {
-              panic("raw statements can't have any thing else with them")
+              return nil, syntax_error(t1.Pos, "raw statements can't have any thing else with them")
             }
-            token_thing = RawToken{Content: "", TokenBase: TokenBase{t1.Pos+1, t1.Line+1, strip_before, strip_after}}
+            token_thing = RawToken{Content: "", TokenBase: base}
             in_raw = true
           default:
-            panic("invalid logical id:'" + id + "'")
+            return nil, syntax_error(t1.Pos, "unknown tag '" + id + "'")
           }
           tokens = append(tokens, token_thing)
         }
-      } else if t1.Val == "{#" {
+      } else if t1.Val == config.CommentStartString || t1.Val == config.LineCommentPrefix {
         // comment block
         //fmt.Println("COMMENT BLOCK: '" + input[t1.Pos:t2.Pos+1] + "'")
       }
-      cur_pos = t2.Pos + 1
+      cur_pos = t2.Pos + len(t2.Val)
       i += 2
     }
   }
   if cur_pos < len(input) {
-    text_token := TextToken{Text: input[cur_pos:], TokenBase: TokenBase{cur_pos - cur_line_pos + 1, cur_line+1, false, false}}
-    tokens = append(tokens, text_token)
+    if text := trimText(input, cur_pos, len(input), trim_next, false, false); text != "" {
+      text_token := TextToken{Text: text, TokenBase: base_at(cur_pos, cur_pos, false, false)}
+      tokens = append(tokens, text_token)
+    }
   }
-  return tokens
+  return tokens, nil
 }
 
 func PeekToken(token Token) string {
@@ -262,6 +817,42 @@ func PeekToken(token Token) string {
     return "raw"
   case EndrawToken:
     return "endraw"
+  case ExtendsToken:
+    return "extends"
+  case BlockToken:
+    return "block"
+  case EndblockToken:
+    return "endblock"
+  case IncludeToken:
+    return "include"
+  case MacroToken:
+    return "macro"
+  case EndmacroToken:
+    return "endmacro"
+  case CallToken:
+    return "call"
+  case EndcallToken:
+    return "endcall"
+  case ImportToken:
+    return "import"
+  case FromImportToken:
+    return "from"
+  case SetToken:
+    return "set"
+  case EndsetToken:
+    return "endset"
+  case FilterToken:
+    return "filter"
+  case EndfilterToken:
+    return "endfilter"
+  case AutoescapeToken:
+    return "autoescape"
+  case EndautoescapeToken:
+    return "endautoescape"
+  case WithToken:
+    return "with"
+  case EndwithToken:
+    return "endwith"
   }
   return "unknown"
 }
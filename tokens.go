@@ -12,12 +12,20 @@ type TokenBoundary struct {
 }
 
 type Token interface {
-  //GetPos() int
+  Base() TokenBase
 }
 
+// TokenBase holds where a token starts in the template: the offset,
+// the line and the column. ContentPos is the offset of the statement
+// inside of a tag, which errors from parsing the statement are
+// relative to.
 type TokenBase struct {
   Pos, Line int
   StripBefore, StripAfter bool
+  Column, ContentPos int
+}
+func (self TokenBase) Base() TokenBase {
+  return self
 }
 
 type TextToken struct {
@@ -62,3 +70,125 @@ type RawToken struct {
 type EndrawToken struct {
   TokenBase
 }
+
+type ExtendsToken struct {
+  TokenBase
+  ExtendsStatement string
+}
+
+type BlockToken struct {
+  TokenBase
+  BlockStatement string
+}
+
+type EndblockToken struct {
+  TokenBase
+  Name string
+}
+
+type IncludeToken struct {
+  TokenBase
+  IncludeStatement string
+}
+
+type MacroToken struct {
+  TokenBase
+  MacroStatement string
+}
+
+type EndmacroToken struct {
+  TokenBase
+}
+
+type CallToken struct {
+  TokenBase
+  CallStatement string
+}
+
+type EndcallToken struct {
+  TokenBase
+}
+
+type ImportToken struct {
+  TokenBase
+  ImportStatement string
+}
+
+type FromImportToken struct {
+  TokenBase
+  FromImportStatement string
+}
+
+type SetToken struct {
+  TokenBase
+  SetStatement string
+}
+
+type EndsetToken struct {
+  TokenBase
+}
+
+type WithToken struct {
+  TokenBase
+  WithStatement string
+}
+
+type EndwithToken struct {
+  TokenBase
+}
+
+type FilterToken struct {
+  TokenBase
+  FilterStatement string
+}
+
+type EndfilterToken struct {
+  TokenBase
+}
+
+type AutoescapeToken struct {
+  TokenBase
+  AutoescapeStatement string
+}
+
+type EndautoescapeToken struct {
+  TokenBase
+}
+
+// TokenStatement returns the statement inside of a tag, or the
+// expression of a variable, and nothing for text and end tags.
+func TokenStatement(token Token) string {
+  switch t := token.(type) {
+  case VariableToken:
+    return t.Content
+  case IfToken:
+    return t.IfStatement
+  case ElifToken:
+    return t.ElifStatement
+  case ForToken:
+    return t.ForStatement
+  case ExtendsToken:
+    return t.ExtendsStatement
+  case BlockToken:
+    return t.BlockStatement
+  case IncludeToken:
+    return t.IncludeStatement
+  case MacroToken:
+    return t.MacroStatement
+  case CallToken:
+    return t.CallStatement
+  case ImportToken:
+    return t.ImportStatement
+  case FromImportToken:
+    return t.FromImportStatement
+  case SetToken:
+    return t.SetStatement
+  case WithToken:
+    return t.WithStatement
+  case FilterToken:
+    return t.FilterStatement
+  case AutoescapeToken:
+    return t.AutoescapeStatement
+  }
+  return ""
+}
@@ -0,0 +1,123 @@
+package jinja2
+
+import (
+  "errors"
+)
+
+// An UndefinedPolicy decides what happens when a template uses a
+// variable or attribute which doesn't exist, in the same way as the
+// Undefined classes of Jinja2.
+type UndefinedPolicy int
+const (
+  // Undefined: renders as an empty string, is false and can be looped
+  // over as an empty list, but looking up an attribute is an error
+  UNDEFINED_LENIENT   UndefinedPolicy = 0
+  // StrictUndefined: anything other than the `defined` test or the
+  // `default` filter is an error
+  UNDEFINED_STRICT    UndefinedPolicy = 1
+  // ChainableUndefined: like lenient, but looking up an attribute
+  // gives back the undefined value, so `a.b.c` works on a missing `a`
+  UNDEFINED_CHAINABLE UndefinedPolicy = 2
+  // DebugUndefined: like lenient, but renders the missing expression
+  // back into the output, as in `{{ a }}`
+  UNDEFINED_DEBUG     UndefinedPolicy = 3
+)
+
+// Undefined is the Data of the PY_TYPE_UNDEFINED value a failed lookup
+// evaluates to. Name is the missing variable or attribute, and Owner is
+// the type of the value the attribute was looked up on, which is empty
+// for variables. Undefined values with no Data are treated as lenient.
+type Undefined struct {
+  Name string
+  Owner string
+  Policy UndefinedPolicy
+}
+
+// UndefinedError is returned when an undefined value is used in a way
+// its policy does not allow.
+type UndefinedError struct {
+  Message string
+}
+func (self *UndefinedError) Error() string {
+  return self.Message
+}
+
+func IsUndefinedError(err error) bool {
+  var undefined_err *UndefinedError
+  return errors.As(err, &undefined_err)
+}
+
+// AsUndefined returns the details of an undefined value.
+func AsUndefined(v VariableType) Undefined {
+  if u, ok := v.Data.(Undefined); ok {
+    return u
+  }
+  return Undefined{}
+}
+
+// Message describes what is undefined, as in the Jinja2 errors.
+func (self Undefined) Message() string {
+  if self.Name == "" {
+    return "value is undefined"
+  } else if self.Owner == "" {
+    return "'" + self.Name + "' is undefined"
+  }
+  return "'" + self.Owner + "' object has no attribute '" + self.Name + "'"
+}
+
+func (self Undefined) Error() error {
+  return &UndefinedError{self.Message()}
+}
+
+// String renders the undefined value according to its policy.
+func (self Undefined) String() (string, error) {
+  switch self.Policy {
+  case UNDEFINED_STRICT:
+    return "", self.Error()
+  case UNDEFINED_DEBUG:
+    if self.Name == "" {
+      return "", nil
+    } else if self.Owner == "" {
+      return "{{ " + self.Name + " }}", nil
+    }
+    return "{{ no such element: " + self.Owner + " object['" + self.Name + "'] }}", nil
+  }
+  return "", nil
+}
+
+// Bool is the truth value of the undefined value, which is always false
+// unless the policy makes using it an error.
+func (self Undefined) Bool() (bool, error) {
+  if self.Policy == UNDEFINED_STRICT {
+    return false, self.Error()
+  }
+  return false, nil
+}
+
+func (self *Context) undefinedPolicy() UndefinedPolicy {
+  if self.Env == nil {
+    return UNDEFINED_LENIENT
+  }
+  return self.Env.Undefined
+}
+
+// undefinedVariable is the value of a variable missing from the context.
+func (self *Context) undefinedVariable(name string) VariableType {
+  return VariableType{PY_TYPE_UNDEFINED, Undefined{name, "", self.undefinedPolicy()}}
+}
+
+// undefinedAttribute is the value of an attribute missing from owner.
+func (self *Context) undefinedAttribute(owner VariableType, name string) VariableType {
+  return VariableType{PY_TYPE_UNDEFINED, Undefined{name, PyTypeToString(owner.Type), self.undefinedPolicy()}}
+}
+
+// undefinedOperand returns the error for using an undefined value as
+// either side of an operator, if one of them is.
+func undefinedOperand(l VariableType, r VariableType) error {
+  if l.Type == PY_TYPE_UNDEFINED {
+    return AsUndefined(l).Error()
+  } else if r.Type == PY_TYPE_UNDEFINED {
+    return AsUndefined(r).Error()
+  }
+  return nil
+}
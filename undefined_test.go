@@ -0,0 +1,87 @@
+package jinja2
+
+import (
+  "strings"
+  "testing"
+)
+
+func renderUndefinedError(t *testing.T, env *Environment, source string) error {
+  template, err := env.FromString(source)
+  if err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  _, err = template.Render(env.NewContext(nil))
+  return err
+}
+
+func TestLenientUndefined(t *testing.T) {
+  env := NewEnvironment(nil)
+  vars := map[string]interface{}{"user": map[interface{}]interface{}{"name": "bob"}}
+  for source, expected := range map[string]string{
+    "[{{ missing }}]": "[]",
+    "[{{ user.age }}]": "[]",
+    "{% for x in missing %}{{ x }}{% else %}empty{% endfor %}": "empty",
+    "{% if missing %}yes{% else %}no{% endif %}": "no",
+    "{% if not missing %}yes{% endif %}": "yes",
+    "{{ missing is defined }} {{ missing is undefined }} {{ user.name is defined }}": "false true true",
+    "{{ missing|default('x') }} {{ user.age|d(7) }} {{ user.name|default('x') }}": "x 7 bob",
+    "{{ ''|default('x') }}|{{ ''|default('x', true) }}": "|x",
+    "{{ missing == missing }} {{ missing == 1 }} {{ missing != 1 }}": "true false true",
+    "[{{ missing|upper }}]": "[]",
+  } {
+    if res := renderWithEnv(t, env, source, vars); res != expected {
+      t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+    }
+  }
+  for _, source := range []string{"{{ missing.attr }}", "{{ missing + 1 }}", "{{ missing() }}"} {
+    if err := renderUndefinedError(t, env, source); !IsUndefinedError(err) {
+      t.Errorf("expected an undefined error rendering '%s', got: %v", source, err)
+    }
+  }
+}
+
+func TestStrictUndefined(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.Undefined = UNDEFINED_STRICT
+  for _, source := range []string{
+    "{{ missing }}",
+    "{% if missing %}{% endif %}",
+    "{% for x in missing %}{% endfor %}",
+    "{{ missing|upper }}",
+    "{{ missing == 1 }}",
+  } {
+    if err := renderUndefinedError(t, env, source); !IsUndefinedError(err) {
+      t.Errorf("expected an undefined error rendering '%s', got: %v", source, err)
+    }
+  }
+  err := renderUndefinedError(t, env, "{{ missing }}")
+  if err == nil || !strings.Contains(err.Error(), "'missing' is undefined") {
+    t.Errorf("wrong undefined error message: %v", err)
+  }
+  source := "{{ missing is defined }} {{ missing|default('x') }}"
+  expected := "false x"
+  if res := renderWithEnv(t, env, source, nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestChainableUndefined(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.Undefined = UNDEFINED_CHAINABLE
+  source := "[{{ a.b.c }}] {{ a.b.c is defined }} {{ a.b|default('x') }}"
+  expected := "[] false x"
+  if res := renderWithEnv(t, env, source, nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestDebugUndefined(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.Undefined = UNDEFINED_DEBUG
+  vars := map[string]interface{}{"user": map[interface{}]interface{}{"name": "bob"}}
+  source := "Hello {{ name }}! {{ user.age }} {{ name|default('x') }}"
+  expected := "Hello {{ name }}! {{ no such element: dict object['age'] }} x"
+  if res := renderWithEnv(t, env, source, vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
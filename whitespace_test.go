@@ -0,0 +1,70 @@
+package jinja2
+
+import (
+  "testing"
+)
+
+func renderWithEnv(t *testing.T, env *Environment, source string, vars map[string]interface{}) string {
+  template, err := env.FromString(source)
+  if err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  res, err := template.Render(env.NewContext(vars))
+  if err != nil {
+    t.Fatalf("error rendering template: %v", err)
+  }
+  return res
+}
+
+func TestWhitespaceControlMarkers(t *testing.T) {
+  env := NewEnvironment(nil)
+  vars := map[string]interface{}{"seq": []interface{}{1, 2, 3}, "v": "X"}
+  for source, expected := range map[string]string{
+    "{% for i in seq -%}\n  {{ i }}\n{%- endfor %}": "123",
+    "a  \n {{- v -}} \n  b": "aXb",
+    "a {{ v -}}\n b": "a Xb",
+    "a\n  {#- comment -#}\n  b": "ab",
+    "{% raw -%}\n  {{ v }}  {%- endraw %}": "{{ v }}",
+  } {
+    if res := renderWithEnv(t, env, source, vars); res != expected {
+      t.Errorf("Template result for '%s' was incorrect. Got: '%s' but expected '%s'", source, res, expected)
+    }
+  }
+}
+
+func TestTrimAndLstripBlocks(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.TrimBlocks = true
+  env.LstripBlocks = true
+  vars := map[string]interface{}{"seq": []interface{}{1, 2}}
+  source := "<ul>\n  {% for i in seq %}\n  <li>{{ i }}</li>\n  {% endfor %}\n</ul>\n"
+  expected := "<ul>\n  <li>1</li>\n  <li>2</li>\n</ul>"
+  if res := renderWithEnv(t, env, source, vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  // a plus sign turns each option back off for a single tag
+  source = "  {%+ if true %}a{% endif +%}\nb\n    {# comment #}\nc"
+  expected = "  a\nb\nc"
+  if res := renderWithEnv(t, env, source, vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  // tags which are not at the start of a line keep the text before them
+  source = "x {% if true %}y{% endif %}"
+  expected = "x y"
+  if res := renderWithEnv(t, env, source, vars); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestKeepTrailingNewline(t *testing.T) {
+  env := NewEnvironment(nil)
+  expected := "line\n"
+  if res := renderWithEnv(t, env, "line\n\n", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  env.KeepTrailingNewline = true
+  expected = "line\n\n"
+  if res := renderWithEnv(t, env, "line\n\n", nil); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
@@ -0,0 +1,42 @@
+package jinja2
+
+import (
+  "testing"
+)
+
+func TestWith(t *testing.T) {
+  source := `{% with a=1, b=foo %}{{ a }}{{ b }}{% endwith %}{{ a is defined }}`
+  expected := "1barfalse"
+  if res := renderString(t, source, map[string]interface{}{"foo": "bar"}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestWithUsesOuterScope(t *testing.T) {
+  // the values are evaluated before any of the new names are set
+  source := `{% with a=2, b=a %}{{ a }}{{ b }}{% endwith %}{{ a }}`
+  expected := "211"
+  if res := renderString(t, source, map[string]interface{}{"a": 1}); res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+}
+
+func TestWithDoesNotClobberContext(t *testing.T) {
+  context := NewContext(map[string]interface{}{"foo": "outer"})
+  template := new(Template)
+  if err := template.Parse(`{% with foo="inner" %}{% set bar = 1 %}{{ foo }}{% endwith %}{{ foo }}`); err != nil {
+    t.Fatalf("error parsing template: %v", err)
+  }
+  expected := "innerouter"
+  if res, err := template.Render(context); err != nil {
+    t.Errorf("error rendering template: %v", err)
+  } else if res != expected {
+    t.Errorf("Template result was incorrect. Got: '%s' but expected '%s'", res, expected)
+  }
+  if v := context.Variables["foo"].Data.(string); v != "outer" {
+    t.Errorf("the with block changed the context variable to '%s'", v)
+  }
+  if _, ok := context.Variables["bar"]; ok {
+    t.Errorf("a variable set inside the with block leaked into the context")
+  }
+}